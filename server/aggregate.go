@@ -0,0 +1,139 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Aggregate is a function used to combine the values within an aggregation window.
+type Aggregate struct {
+	// Function is the name of the Flux function, e.g. "mean" or "quantile"
+	Function string
+
+	// Quantile is the requested quantile in range [0, 1] when Function is "quantile"
+	Quantile float64
+}
+
+var (
+	AggregateMean = Aggregate{Function: "mean"}
+
+	aggregateFunctions = map[string]bool{
+		"mean":   true,
+		"min":    true,
+		"max":    true,
+		"median": true,
+		"first":  true,
+		"last":   true,
+		"sum":    true,
+		"count":  true,
+		"stddev": true,
+	}
+)
+
+// ParseAggregate parses an aggregate given as a function name, e.g. "max",
+// or as a percentile, e.g. "p95" or "p99.9".
+// Empty string means the default, mean.
+func ParseAggregate(s string) (Aggregate, error) {
+	if s == "" {
+		return AggregateMean, nil
+	}
+	if aggregateFunctions[s] {
+		return Aggregate{Function: s}, nil
+	}
+	if strings.HasPrefix(s, "p") {
+		percentile, err := strconv.ParseFloat(s[1:], 64)
+		if err != nil || math.IsNaN(percentile) || percentile < 0 || percentile > 100 {
+			return Aggregate{}, fmt.Errorf("invalid percentile: %s", s)
+		}
+		return Aggregate{Function: "quantile", Quantile: percentile / 100}, nil
+	}
+	return Aggregate{}, errors.New("unknown aggregate: " + s)
+}
+
+// String returns the aggregate in the format accepted by ParseAggregate.
+func (a Aggregate) String() string {
+	if a.Function == "quantile" {
+		return "p" + strconv.FormatFloat(a.Quantile*100, 'f', -1, 64)
+	}
+	return a.Function
+}
+
+// apply computes the aggregate of values, which must be in time order.
+func (a Aggregate) apply(values []float64) float64 {
+	if len(values) == 0 {
+		return math.NaN()
+	}
+	switch a.Function {
+	case "min":
+		min := values[0]
+		for _, v := range values[1:] {
+			min = math.Min(min, v)
+		}
+		return min
+	case "max":
+		max := values[0]
+		for _, v := range values[1:] {
+			max = math.Max(max, v)
+		}
+		return max
+	case "median":
+		return quantile(values, 0.5)
+	case "quantile":
+		return quantile(values, a.Quantile)
+	case "first":
+		return values[0]
+	case "last":
+		return values[len(values)-1]
+	case "sum":
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return sum
+	case "count":
+		return float64(len(values))
+	case "stddev":
+		return stddev(values)
+	default:
+		return mean(values)
+	}
+}
+
+func mean(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// stddev computes the sample standard deviation, like Flux stddev() does by default.
+func stddev(values []float64) float64 {
+	if len(values) < 2 {
+		return 0
+	}
+	m := mean(values)
+	var squares float64
+	for _, v := range values {
+		squares += (v - m) * (v - m)
+	}
+	return math.Sqrt(squares / float64(len(values)-1))
+}
+
+// quantile computes the q quantile of values using linear interpolation between closest ranks.
+func quantile(values []float64, q float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	pos := q * float64(len(sorted)-1)
+	lower := int(math.Floor(pos))
+	upper := int(math.Ceil(pos))
+	if lower == upper {
+		return sorted[lower]
+	}
+	return sorted[lower] + (pos-float64(lower))*(sorted[upper]-sorted[lower])
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseAggregate(t *testing.T) {
+	valid := map[string]Aggregate{
+		"":      AggregateMean,
+		"mean":  AggregateMean,
+		"max":   {Function: "max"},
+		"count": {Function: "count"},
+		"p0":    {Function: "quantile", Quantile: 0},
+		"p50":   {Function: "quantile", Quantile: 0.5},
+		"p95":   {Function: "quantile", Quantile: 0.95},
+		"p99.9": {Function: "quantile", Quantile: 0.999},
+		"p100":  {Function: "quantile", Quantile: 1},
+	}
+	for value, want := range valid {
+		got, err := ParseAggregate(value)
+		if err != nil {
+			t.Errorf("ParseAggregate(%q): %v", value, err)
+			continue
+		}
+		if got.Function != want.Function || math.Abs(got.Quantile-want.Quantile) > 1e-12 {
+			t.Errorf("ParseAggregate(%q) = %+v, want %+v", value, got, want)
+		}
+		if value != "" {
+			if s := got.String(); s != value {
+				t.Errorf("ParseAggregate(%q) formats as %q", value, s)
+			}
+		}
+	}
+
+	for _, value := range []string{
+		"average",
+		"MAX",
+		"quantile",
+		"p",
+		"p-1",
+		"p100.1",
+		"pNaN",
+		"pInf",
+		"p95%",
+		"95",
+	} {
+		if got, err := ParseAggregate(value); err == nil {
+			t.Errorf("ParseAggregate(%q) = %+v, want an error", value, got)
+		}
+	}
+}
+
+func TestAggregateApply(t *testing.T) {
+	// in time order, the sorted values are 1, 2, 3, 4, 10
+	values := []float64{4, 1, 3, 2, 10}
+	tests := []struct {
+		aggregate string
+		want      float64
+	}{
+		{"mean", 4},
+		{"min", 1},
+		{"max", 10},
+		{"median", 3},
+		{"first", 4},
+		{"last", 10},
+		{"sum", 20},
+		{"count", 5},
+		{"stddev", math.Sqrt(12.5)},
+		{"p0", 1},
+		{"p25", 2},
+		{"p90", 7.6},
+		{"p95", 8.8},
+		{"p100", 10},
+	}
+	for _, tt := range tests {
+		aggregate, err := ParseAggregate(tt.aggregate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := aggregate.apply(values); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s of %v = %v, want %v", tt.aggregate, values, got, tt.want)
+		}
+	}
+	if values[0] != 4 || values[1] != 1 {
+		t.Errorf("apply reordered its input to %v", values)
+	}
+
+	if got := (Aggregate{Function: "stddev"}).apply([]float64{5}); got != 0 {
+		t.Errorf("stddev of a single value = %v, want 0", got)
+	}
+	if got := AggregateMean.apply(nil); !math.IsNaN(got) {
+		t.Errorf("aggregate of no values = %v, want NaN", got)
+	}
+}
@@ -3,9 +3,11 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"math"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -13,31 +15,50 @@ import (
 	"time"
 
 	"github.com/LassiHeikkila/mokki-cloud/server/auth"
+	"github.com/LassiHeikkila/mokki-cloud/server/registry"
 )
 
 var (
 	RootPath = "www/index.html"
+)
 
-	QueryLatest func(
-		ctx context.Context,
-		field string,
-		id string,
-	) Measurement = nil
-
-	QueryTimeRange func(
-		ctx context.Context,
-		field string,
-		id string,
-		start time.Time,
-		stop time.Time,
-		interval time.Duration,
-	) []Measurement = nil
+const (
+	// LTTB always keeps the first and last points, so fewer than three would not downsample anything
+	minDownsampledPoints = 3
 )
 
+// Response headers describing how range data was processed.
 const (
-	defaultRangeInterval = 30 * time.Minute
+	aggregateHeader = "X-Aggregate"
+	intervalHeader  = "X-Interval"
+	rejectedHeader  = "X-Rejected"
 )
 
+// ExposedHeaders lists response headers which browsers should let clients read.
+var ExposedHeaders = []string{
+	aggregateHeader,
+	intervalHeader,
+	rejectedHeader,
+}
+
+// Handler serves the data API from a Store.
+type Handler struct {
+	store Store
+
+	// location is the default time zone for calendar windows and relative times
+	location *time.Location
+}
+
+func NewHandler(store Store, location *time.Location) *Handler {
+	if location == nil {
+		location = time.UTC
+	}
+	return &Handler{
+		store:    withVirtualFields(withDespiking(store)),
+		location: location,
+	}
+}
+
 func HandleRoot(w http.ResponseWriter, req *http.Request) {
 	defer req.Body.Close()
 	b, err := ioutil.ReadFile(RootPath)
@@ -54,20 +75,34 @@ func Authenticated(w http.ResponseWriter, req *http.Request) bool {
 	if key == "" {
 		return false
 	}
-	return auth.TokenIsValid(key)
+	return auth.TokenIsValid(key) || auth.IsAdminToken(key)
 }
 
-func HandleRequest(w http.ResponseWriter, req *http.Request) {
+// IsAdmin returns true if the request is authorized to make changes,
+// which requires the admin token in X-API-KEY.
+func IsAdmin(req *http.Request) bool {
+	key := req.Header.Get("X-API-KEY")
+	if key == "" {
+		return false
+	}
+	return auth.IsAdminToken(key)
+}
+
+func (h *Handler) HandleRequest(w http.ResponseWriter, req *http.Request) {
 	if req.URL.Path == "/" {
 		HandleRoot(w, req)
 		return
 	}
 	if strings.HasSuffix(req.URL.Path, "/latest") {
-		HandleLatest(w, req)
+		h.HandleLatest(w, req)
 		return
 	}
 	if strings.HasSuffix(req.URL.Path, "/range") {
-		HandleRange(w, req)
+		h.HandleRange(w, req)
+		return
+	}
+	if strings.HasSuffix(req.URL.Path, "/stats") {
+		h.HandleStats(w, req)
 		return
 	}
 }
@@ -128,7 +163,7 @@ func HandleAuthorization(w http.ResponseWriter, req *http.Request) {
 	_, _ = w.Write(b)
 }
 
-func HandleLatest(w http.ResponseWriter, req *http.Request) {
+func (h *Handler) HandleLatest(w http.ResponseWriter, req *http.Request) {
 	defer req.Body.Close()
 	if !Authenticated(w, req) {
 		http.Error(w, "unauthorized", http.StatusUnauthorized)
@@ -146,23 +181,21 @@ func HandleLatest(w http.ResponseWriter, req *http.Request) {
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
-	data := QueryLatest(req.Context(), field, id)
-	if data == nil {
-		log.Println("nil data returned from query")
-		http.Error(w, "no data found for given parameters", http.StatusNotFound)
+	units, err := ParseUnits(req.URL.Query().Get("units"))
+	if err != nil {
+		log.Println("error getting units from query:", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
-	b, err := json.Marshal(data)
+	data, err := withUnits(h.store, units).Latest(req.Context(), field, id)
 	if err != nil {
-		log.Println("error marshalling data:", err)
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+		writeStoreError(w, h.checkSensorKnown(req.Context(), id, err))
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	_, _ = w.Write(b)
+	writeJSON(w, data)
 }
 
-func HandleRange(w http.ResponseWriter, req *http.Request) {
+func (h *Handler) HandleRange(w http.ResponseWriter, req *http.Request) {
 	defer req.Body.Close()
 	if !Authenticated(w, req) {
 		http.Error(w, "unauthorized", http.StatusUnauthorized)
@@ -180,30 +213,490 @@ func HandleRange(w http.ResponseWriter, req *http.Request) {
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
-	start, err := getTimeFromQuery(req.URL.Query(), "from")
+	location, err := h.getLocationFromQuery(req.URL.Query())
 	if err != nil {
-		log.Println("error getting start time from query:", err)
+		log.Println("error getting time zone from query:", err)
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
-	stop, err := getTimeFromQuery(req.URL.Query(), "to")
+	start, stop, since, err := getTimeRangeFromQuery(req.URL.Query(), time.Now().In(location))
 	if err != nil {
-		log.Println("error getting stop time from query:", err)
+		log.Println("error getting time range from query:", err)
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
-	interval, err := getDurationFromQueryOrDefault(req.URL.Query(), "interval", defaultRangeInterval)
+	defaultInterval := autoInterval(stop.Sub(start), targetRangePoints)
+	interval, months, err := getIntervalFromQueryOrDefault(req.URL.Query(), "interval", defaultInterval)
 	if err != nil {
-		log.Println("error getting interval from query:", err)
+		log.Println("invalid interval in query:", req.URL.Query().Get("interval"))
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
-	data := QueryTimeRange(req.Context(), field, id, start, stop, interval)
-	if data == nil {
-		log.Println("nil data returned from query")
-		http.Error(w, "no data found for given parameters", http.StatusNotFound)
+	envelope, err := getBoolFromQueryOrDefault(req.URL.Query(), "envelope", false)
+	if err != nil {
+		log.Println("error getting envelope from query:", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	gaps, err := getBoolFromQueryOrDefault(req.URL.Query(), "gaps", false)
+	if err != nil {
+		log.Println("error getting gaps from query:", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	maxPoints, err := getIntFromQueryOrDefault(req.URL.Query(), "maxPoints", 0)
+	if err != nil || maxPoints < 0 || (maxPoints > 0 && maxPoints < minDownsampledPoints) {
+		log.Println("invalid maxPoints in query:", req.URL.Query().Get("maxPoints"))
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	agg, err := fieldAggregate(field, req.URL.Query().Get("agg"))
+	if err != nil {
+		log.Println("error getting aggregate from query:", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	raw, despike, err := getFilteringFromQuery(req.URL.Query())
+	if err != nil {
+		log.Println("error getting filtering from query:", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	units, err := ParseUnits(req.URL.Query().Get("units"))
+	if err != nil {
+		log.Println("error getting units from query:", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	query := RangeQuery{
+		Field:     field,
+		SensorID:  id,
+		Start:     start,
+		Stop:      stop,
+		Since:     since,
+		Interval:  interval,
+		Aggregate: agg,
+		Months:    months,
+		Location:  location,
+		Raw:       raw,
+		Despike:   despike,
+	}
+
+	store := withUnits(h.store, units)
+
+	switch {
+	case maxPoints > 0:
+		if req.URL.Query().Get("agg") != "" || envelope || gaps {
+			log.Println("maxPoints cannot be combined with agg, envelope or gaps")
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		data, rejected, err := h.downsampledRange(req.Context(), query, maxPoints)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		w.Header().Set(rejectedHeader, strconv.Itoa(rejected))
+		w.Header().Set(aggregateHeader, "lttb")
+		writeJSON(w, units.convertAll(data, ""))
+	case gaps:
+		if envelope {
+			log.Println("gaps cannot be combined with envelope")
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if windowCount(query) > maxGapWindows {
+			log.Printf("too many windows for gaps, at most %d allowed\n", maxGapWindows)
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		measurements, rejected, err := store.Range(req.Context(), query)
+		if err != nil {
+			// a range without data is one long gap, unless the sensor does not exist at all
+			if err = h.checkSensorKnown(req.Context(), id, err); !errors.Is(err, ErrNoData) {
+				writeStoreError(w, err)
+				return
+			}
+		}
+		w.Header().Set(rejectedHeader, strconv.Itoa(rejected))
+		w.Header().Set(aggregateHeader, agg.String())
+		w.Header().Set(intervalHeader, formatInterval(interval, months))
+		unit := units.unit(field)
+		if agg.Function == "count" {
+			unit = ""
+		}
+		writeJSON(w, fillGaps(query, measurements, unit))
+	case envelope:
+		data, rejected, err := store.Envelope(req.Context(), query)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		w.Header().Set(rejectedHeader, strconv.Itoa(rejected))
+		w.Header().Set(aggregateHeader, "envelope")
+		w.Header().Set(intervalHeader, formatInterval(interval, months))
+		writeJSON(w, data)
+	default:
+		w.Header().Set(aggregateHeader, agg.String())
+		w.Header().Set(intervalHeader, formatInterval(interval, months))
+		streamMeasurements(w, func(fn func(Measurement) error) error {
+			// the count arrives before the first measurement, while headers can still be set
+			return store.StreamRange(req.Context(), query, func(rejected int) {
+				w.Header().Set(rejectedHeader, strconv.Itoa(rejected))
+			}, fn)
+		})
+	}
+}
+
+func (h *Handler) HandleStats(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	if !Authenticated(w, req) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := getSensorIDFromPath(req.URL.Path)
+	if err != nil {
+		log.Printf("error getting sensor id from request path (%s): %s\n", req.URL.Path, err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	field, err := getFieldFromPath(req.URL.Path)
+	if err != nil {
+		log.Printf("error getting field from request path (%s): %s\n", req.URL.Path, err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	location, err := h.getLocationFromQuery(req.URL.Query())
+	if err != nil {
+		log.Println("error getting time zone from query:", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	start, stop, since, err := getTimeRangeFromQuery(req.URL.Query(), time.Now().In(location))
+	if err != nil {
+		log.Println("error getting time range from query:", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	raw, despike, err := getFilteringFromQuery(req.URL.Query())
+	if err != nil {
+		log.Println("error getting filtering from query:", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	units, err := ParseUnits(req.URL.Query().Get("units"))
+	if err != nil {
+		log.Println("error getting units from query:", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	data, err := withUnits(h.store, units).Stats(req.Context(), RangeQuery{
+		Field:    field,
+		SensorID: id,
+		Start:    start,
+		Stop:     stop,
+		Since:    since,
+		Location: location,
+		Raw:      raw,
+		Despike:  despike,
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, data)
+}
+
+// HandleCompare returns the range of a field over a base period and over periods offset from it,
+// with timestamps realigned onto the base period, along with a summary of each period.
+func (h *Handler) HandleCompare(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	if !Authenticated(w, req) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := getSensorIDFromPath(req.URL.Path)
+	if err != nil {
+		log.Printf("error getting sensor id from request path (%s): %s\n", req.URL.Path, err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	field, err := getFieldFromPath(req.URL.Path)
+	if err != nil {
+		log.Printf("error getting field from request path (%s): %s\n", req.URL.Path, err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	location, err := h.getLocationFromQuery(req.URL.Query())
+	if err != nil {
+		log.Println("error getting time zone from query:", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	start, stop, _, err := getTimeRangeFromQuery(req.URL.Query(), time.Now().In(location))
+	if err != nil {
+		log.Println("error getting time range from query:", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	offsets, err := parsePeriodOffsets(req.URL.Query().Get("offsets"))
+	if err != nil {
+		log.Println("error getting offsets from query:", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	defaultInterval := autoInterval(stop.Sub(start), targetRangePoints)
+	interval, months, err := getIntervalFromQueryOrDefault(req.URL.Query(), "interval", defaultInterval)
+	if err != nil {
+		log.Println("invalid interval in query:", req.URL.Query().Get("interval"))
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	agg, err := fieldAggregate(field, req.URL.Query().Get("agg"))
+	if err != nil {
+		log.Println("error getting aggregate from query:", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	raw, despike, err := getFilteringFromQuery(req.URL.Query())
+	if err != nil {
+		log.Println("error getting filtering from query:", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	units, err := ParseUnits(req.URL.Query().Get("units"))
+	if err != nil {
+		log.Println("error getting units from query:", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	query := RangeQuery{
+		Field:     field,
+		SensorID:  id,
+		Start:     start,
+		Stop:      stop,
+		Interval:  interval,
+		Aggregate: agg,
+		Months:    months,
+		Location:  location,
+		Raw:       raw,
+		Despike:   despike,
+	}
+
+	data, rejected, err := compareRange(req.Context(), withUnits(h.store, units), query, offsets)
+	if err != nil {
+		writeStoreError(w, h.checkSensorKnown(req.Context(), id, err))
+		return
+	}
+	data.Unit = units.unit(field)
+	w.Header().Set(rejectedHeader, strconv.Itoa(rejected))
+	w.Header().Set(aggregateHeader, agg.String())
+	w.Header().Set(intervalHeader, formatInterval(interval, months))
+	writeJSON(w, data)
+}
+
+func (h *Handler) HandleDegreeDays(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	if !Authenticated(w, req) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := getSensorIDFromAnalysisPath(req.URL.Path)
+	if err != nil {
+		log.Printf("error getting sensor id from request path (%s): %s\n", req.URL.Path, err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	location, err := h.getLocationFromQuery(req.URL.Query())
+	if err != nil {
+		log.Println("error getting time zone from query:", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	start, stop, since, err := getTimeRangeFromQuery(req.URL.Query(), time.Now().In(location))
+	if err != nil {
+		log.Println("error getting time range from query:", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	units, err := ParseUnits(req.URL.Query().Get("units"))
+	if err != nil {
+		log.Println("error getting units from query:", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
+	defaultBase := units.convertValue("temperature", defaultHeatingBase, "")
+	base, err := getFloatFromQueryOrDefault(req.URL.Query(), "base", defaultBase)
+	if err == nil && (math.IsNaN(base) || math.IsInf(base, 0)) {
+		err = fmt.Errorf("base must be finite: %v", base)
+	}
+	if err != nil {
+		log.Println("error getting base temperature from query:", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	period := req.URL.Query().Get("period")
+	if period == "" {
+		period = "month"
+	}
+	if _, err := periodStart(period, time.Time{}); err != nil {
+		log.Println("error getting period from query:", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	data, err := computeDegreeDays(req.Context(), h.store, units, RangeQuery{
+		SensorID: id,
+		Start:    start,
+		Stop:     stop,
+		Since:    since,
+		Location: location,
+	}, base, period)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, data)
+}
+
+func (h *Handler) HandleMould(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	if !Authenticated(w, req) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := getSensorIDFromAnalysisPath(req.URL.Path)
+	if err != nil {
+		log.Printf("error getting sensor id from request path (%s): %s\n", req.URL.Path, err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	location, err := h.getLocationFromQuery(req.URL.Query())
+	if err != nil {
+		log.Println("error getting time zone from query:", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	start, stop, since, err := getTimeRangeFromQuery(req.URL.Query(), time.Now().In(location))
+	if err != nil {
+		log.Println("error getting time range from query:", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	sensitivityName := req.URL.Query().Get("sensitivity")
+	if sensitivityName == "" {
+		sensitivityName = defaultMouldSensitivity
+	}
+	sensitivity, err := ParseMouldSensitivity(sensitivityName)
+	if err != nil {
+		log.Println("error getting sensitivity from query:", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	data, err := computeMouldIndex(req.Context(), h.store, RangeQuery{
+		SensorID: id,
+		Start:    start,
+		Stop:     stop,
+		Since:    since,
+		Location: location,
+	}, sensitivity, sensitivityName)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, data)
+}
+
+func (h *Handler) HandleSensors(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	if !Authenticated(w, req) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	data, err := h.store.Sensors(req.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	for i := range data {
+		if sensor, ok := registry.Get(data[i].ID); ok {
+			data[i].Metadata = &sensor
+		}
+	}
+	writeJSON(w, data)
+}
+
+func (h *Handler) HandleSensorStatus(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	if !Authenticated(w, req) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	window := defaultStatusWindow
+	if value := req.URL.Query().Get("window"); value != "" {
+		var err error
+		window, err = parseRelativeDuration(value)
+		if err != nil || window <= 0 || window > maxStatusWindow {
+			log.Println("invalid window in query:", value, err)
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+	}
+	sensors, err := h.store.Sensors(req.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	activity, err := h.store.Activity(req.Context(), window)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, sensorStatuses(sensors, activity, window, time.Now()))
+}
+
+func (h *Handler) HandleSensorFields(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	if !Authenticated(w, req) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := getSensorIDFromSensorsPath(req.URL.Path)
+	if err != nil {
+		log.Printf("error getting sensor id from request path (%s): %s\n", req.URL.Path, err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	data, err := h.store.Fields(req.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, data)
+}
+
+func (h *Handler) HandleSnapshot(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	if !Authenticated(w, req) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	units, err := ParseUnits(req.URL.Query().Get("units"))
+	if err != nil {
+		log.Println("error getting units from query:", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	data, err := withUnits(h.store, units).Snapshot(req.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, data)
+}
+
+func writeJSON(w http.ResponseWriter, data interface{}) {
 	b, err := json.Marshal(data)
 	if err != nil {
 		log.Println("error marshalling data:", err)
@@ -214,6 +707,99 @@ func HandleRange(w http.ResponseWriter, req *http.Request) {
 	_, _ = w.Write(b)
 }
 
+// downsampledRange gets raw measurements matching query and downsamples them
+// to at most maxPoints measurements using LTTB.
+// The number of values left out as implausible is returned too.
+func (h *Handler) downsampledRange(ctx context.Context, query RangeQuery, maxPoints int) ([]Measurement, int, error) {
+	query.Interval = 0
+	var (
+		samples  []sample
+		rejected int
+	)
+	err := h.store.StreamRange(ctx, query, func(n int) {
+		rejected = n
+	}, func(m Measurement) error {
+		if v, ok := floatValue(m); ok {
+			samples = append(samples, sample{t: m.Time(), v: v})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	samples = lttb(samples, maxPoints)
+	measurements := make([]Measurement, 0, len(samples))
+	for _, s := range samples {
+		m, err := NewMeasurement(query.Field, query.SensorID, s.v, s.t)
+		if err != nil {
+			return nil, 0, err
+		}
+		measurements = append(measurements, m)
+	}
+	return measurements, rejected, nil
+}
+
+// streamMeasurements writes measurements produced by stream to w as a JSON array,
+// encoding each one as soon as it is produced.
+// Errors before the first measurement are reported with a proper status code.
+// Errors after that abort the response, so that clients don't mistake
+// the truncated array for a complete one.
+func streamMeasurements(w http.ResponseWriter, stream func(fn func(Measurement) error) error) {
+	started := false
+	encoder := json.NewEncoder(w)
+	err := stream(func(m Measurement) error {
+		separator := []byte(",")
+		if !started {
+			w.Header().Set("Content-Type", "application/json")
+			separator = []byte("[")
+			started = true
+		}
+		if _, err := w.Write(separator); err != nil {
+			return err
+		}
+		return encoder.Encode(m)
+	})
+	if err != nil {
+		if !started {
+			writeStoreError(w, err)
+			return
+		}
+		log.Println("error while streaming response:", err)
+		panic(http.ErrAbortHandler)
+	}
+	_, _ = w.Write([]byte("]"))
+}
+
+// checkSensorKnown replaces ErrNoData with ErrUnknownSensor if sensor id has never reported anything,
+// so that a sensor which has gone quiet can be told apart from a mistyped ID.
+func (h *Handler) checkSensorKnown(ctx context.Context, id string, err error) error {
+	if !errors.Is(err, ErrNoData) {
+		return err
+	}
+	if _, fieldsErr := h.store.Fields(ctx, id); errors.Is(fieldsErr, ErrNoData) {
+		return ErrUnknownSensor
+	}
+	return err
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrUnknownSensor) {
+		http.Error(w, "unknown sensor", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, ErrNoData) {
+		http.Error(w, "no data found for given parameters", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, ErrCalibrationChanged) {
+		http.Error(w, "calibration changes within given range", http.StatusBadRequest)
+		return
+	}
+	log.Println("error running query:", err)
+	http.Error(w, "internal server error", http.StatusInternalServerError)
+}
+
 func getFieldFromPath(path string) (string, error) {
 	// /api/data/{field}/{id}/latest
 	// field is third item, but split counts the empty value before the first /
@@ -224,6 +810,7 @@ func getFieldFromPath(path string) (string, error) {
 	return segments[3], nil
 }
 
+// getSensorIDFromPath returns the MAC address of the sensor in path, which may name it by its alias.
 func getSensorIDFromPath(path string) (string, error) {
 	// /api/data/{field}/{id}/latest
 	// id is fourth item, but split counts the empty value before the first /
@@ -231,25 +818,150 @@ func getSensorIDFromPath(path string) (string, error) {
 	if len(segments) != 6 {
 		return "", fmt.Errorf("malformed path: %v", segments)
 	}
-	return segments[4], nil
+	return registry.Resolve(segments[4]), nil
+}
+
+func getSensorIDFromSensorsPath(path string) (string, error) {
+	// /api/sensors/{id}/fields
+	// id is third item, but split counts the empty value before the first /
+	segments := strings.Split(path, "/")
+	if len(segments) != 5 {
+		return "", fmt.Errorf("malformed path: %v", segments)
+	}
+	return registry.Resolve(segments[3]), nil
+}
+
+func getSensorIDFromAnalysisPath(path string) (string, error) {
+	// /api/degreedays/{id} or /api/mould/{id}
+	// id is third item, but split counts the empty value before the first /
+	segments := strings.Split(path, "/")
+	if len(segments) != 4 {
+		return "", fmt.Errorf("malformed path: %v", segments)
+	}
+	return registry.Resolve(segments[3]), nil
+}
+
+// getTimeRangeFromQuery gets the time range given by "from" and "to" in values.
+// Both accept RFC3339 timestamps or expressions relative to now, see parseTime.
+// "to" defaults to now. If "from" is a duration into the past and "to" is now,
+// the duration is returned as well so that the query can be made relative to the database's clock.
+func getTimeRangeFromQuery(values url.Values, now time.Time) (time.Time, time.Time, time.Duration, error) {
+	from := values.Get("from")
+	if from == "" {
+		return time.Time{}, time.Time{}, 0, errors.New("requested key from not present")
+	}
+	to := values.Get("to")
+	if to == "" {
+		to = "now"
+	}
+
+	start, since, err := parseTime(from, now)
+	if err != nil {
+		return time.Time{}, time.Time{}, 0, err
+	}
+	stop, _, err := parseTime(to, now)
+	if err != nil {
+		return time.Time{}, time.Time{}, 0, err
+	}
+	if !start.Before(stop) {
+		return time.Time{}, time.Time{}, 0, fmt.Errorf("from (%s) is not before to (%s)", from, to)
+	}
+	if to != "now" {
+		since = 0
+	}
+	return start, stop, since, nil
+}
+
+// getIntervalFromQueryOrDefault gets an aggregation interval given either as seconds,
+// or with a unit, e.g. "15m", "1d" or "1w". Calendar months are given as e.g. "1mo",
+// in which case the number of months is returned instead of a duration.
+func getIntervalFromQueryOrDefault(values url.Values, key string, defaultDuration time.Duration) (time.Duration, int, error) {
+	value := values.Get(key)
+	if value == "" {
+		return defaultDuration, 0, nil
+	}
+	if strings.HasSuffix(value, "mo") {
+		months, err := strconv.Atoi(strings.TrimSuffix(value, "mo"))
+		if err != nil || months <= 0 {
+			return 0, 0, fmt.Errorf("invalid number of months: %s", value)
+		}
+		return 0, months, nil
+	}
+	if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if i <= 0 {
+			return 0, 0, fmt.Errorf("interval must be positive: %s", value)
+		}
+		return time.Duration(i) * time.Second, 0, nil
+	}
+	d, err := parseRelativeDuration(value)
+	return d, 0, err
 }
 
-func getTimeFromQuery(values url.Values, key string) (time.Time, error) {
+// LoadLocation loads the time zone with IANA name, e.g. Europe/Helsinki.
+// Local is rejected, as the name of the local time zone of the server is unknown to InfluxDB.
+func LoadLocation(name string) (*time.Location, error) {
+	if name == "Local" {
+		return nil, errors.New("time zone must be an IANA name: Local")
+	}
+	return time.LoadLocation(name)
+}
+
+// getLocationFromQuery gets the time zone given as an IANA name in "tz",
+// or the default time zone of h.
+func (h *Handler) getLocationFromQuery(values url.Values) (*time.Location, error) {
+	name := values.Get("tz")
+	if name == "" {
+		return h.location, nil
+	}
+	return LoadLocation(name)
+}
+
+// formatInterval formats an interval like the interval query parameter accepts it:
+// calendar months as e.g. "1mo" and durations as seconds.
+func formatInterval(d time.Duration, months int) string {
+	if months > 0 {
+		return strconv.Itoa(months) + "mo"
+	}
+	return strconv.FormatInt(int64(d/time.Second), 10)
+}
+
+// getFilteringFromQuery gets "raw", which keeps implausible values, and "despike",
+// which enables the spike filter. Raw data cannot be despiked.
+func getFilteringFromQuery(values url.Values) (bool, bool, error) {
+	raw, err := getBoolFromQueryOrDefault(values, "raw", false)
+	if err != nil {
+		return false, false, err
+	}
+	despike, err := getBoolFromQueryOrDefault(values, "despike", false)
+	if err != nil {
+		return false, false, err
+	}
+	if raw && despike {
+		return false, false, errors.New("raw cannot be combined with despike")
+	}
+	return raw, despike, nil
+}
+
+func getBoolFromQueryOrDefault(values url.Values, key string, defaultValue bool) (bool, error) {
 	value := values.Get(key)
 	if value == "" {
-		return time.Time{}, fmt.Errorf("requested key %s not present", key)
+		return defaultValue, nil
 	}
-	return time.Parse(time.RFC3339Nano, value)
+	return strconv.ParseBool(value)
 }
 
-func getDurationFromQueryOrDefault(values url.Values, key string, defaultDuration time.Duration) (time.Duration, error) {
+func getIntFromQueryOrDefault(values url.Values, key string, defaultValue int) (int, error) {
 	value := values.Get(key)
 	if value == "" {
-		return defaultDuration, nil
+		return defaultValue, nil
 	}
-	i, err := strconv.ParseInt(value, 10, 64)
-	if err != nil {
-		return 0, err
+	return strconv.Atoi(value)
+}
+
+func getFloatFromQueryOrDefault(values url.Values, key string, defaultValue float64) (float64, error) {
+	value := values.Get(key)
+	if value == "" {
+		return defaultValue, nil
 	}
-	return time.Duration(i) * time.Second, nil
+	return strconv.ParseFloat(value, 64)
 }
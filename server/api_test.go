@@ -0,0 +1,138 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/LassiHeikkila/mokki-cloud/server/auth"
+)
+
+const testToken = "test-token"
+
+// testHandler returns a Handler serving temperatures of sensor "sensor" reported every minute from start,
+// accepting testToken.
+func testHandler(t *testing.T, start time.Time, values ...float64) *Handler {
+	t.Helper()
+	auth.SetAdminToken(testToken)
+	t.Cleanup(func() {
+		auth.SetAdminToken("")
+	})
+	return NewHandler(temperatureStore(t, start, values...), time.UTC)
+}
+
+func get(h *Handler, path string, query url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path+"?"+query.Encode(), nil)
+	req.Header.Set("X-API-KEY", testToken)
+	rec := httptest.NewRecorder()
+	h.HandleRequest(rec, req)
+	return rec
+}
+
+func TestHandleLatest(t *testing.T) {
+	start := time.Date(2022, 10, 30, 0, 0, 0, 0, time.UTC)
+	h := testHandler(t, start, 20, 21.5)
+
+	rec := get(h, "/api/data/temperature/sensor/latest", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body["sensorID"] != "sensor" || body["temperature"] != 21.5 || body["unit"] != "°C" {
+		t.Errorf("unexpected body: %s", rec.Body)
+	}
+
+	tests := []struct {
+		path  string
+		query url.Values
+		code  int
+	}{
+		{"/api/data/temperature/unknown/latest", nil, http.StatusNotFound},
+		{"/api/data/humidity/sensor/latest", nil, http.StatusNotFound},
+		{"/api/data/temperature/sensor/latest", url.Values{"units": {"temperature=X"}}, http.StatusBadRequest},
+		{"/api/data/temperature/latest", nil, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if rec := get(h, tt.path, tt.query); rec.Code != tt.code {
+			t.Errorf("%s?%s: status %d, want %d", tt.path, tt.query.Encode(), rec.Code, tt.code)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/data/temperature/sensor/latest", nil)
+	rec = httptest.NewRecorder()
+	h.HandleRequest(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("without a token: status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestHandleRange(t *testing.T) {
+	start := time.Date(2022, 10, 30, 0, 0, 0, 0, time.UTC)
+	h := testHandler(t, start, 20, 150, 21, 22)
+	query := url.Values{
+		"from":     {start.Format(time.RFC3339)},
+		"to":       {start.Add(time.Hour).Format(time.RFC3339)},
+		"interval": {"2m"},
+	}
+
+	rec := get(h, "/api/data/temperature/sensor/range", query)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get(rejectedHeader); got != "1" {
+		t.Errorf("%s = %q, want 1", rejectedHeader, got)
+	}
+	if got := rec.Header().Get(aggregateHeader); got != "mean" {
+		t.Errorf("%s = %q, want mean", aggregateHeader, got)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+	var body []map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("%v: %s", err, rec.Body)
+	}
+	want := []float64{20, 21.5}
+	if len(body) != len(want) {
+		t.Fatalf("got %d measurements, want %d: %s", len(body), len(want), rec.Body)
+	}
+	for i, m := range body {
+		if m["temperature"] != want[i] {
+			t.Errorf("measurement %d is %v, want %v", i, m["temperature"], want[i])
+		}
+	}
+
+	tests := []struct {
+		path   string
+		change url.Values
+		code   int
+	}{
+		{"/api/data/temperature/unknown/range", nil, http.StatusNotFound},
+		{"/api/data/temperature/sensor/range", url.Values{"from": {start.Add(2 * time.Hour).Format(time.RFC3339)}, "to": {start.Add(3 * time.Hour).Format(time.RFC3339)}}, http.StatusNotFound},
+		{"/api/data/temperature/sensor/range", url.Values{"from": {""}}, http.StatusBadRequest},
+		{"/api/data/temperature/sensor/range", url.Values{"from": {"yesterday"}}, http.StatusBadRequest},
+		{"/api/data/temperature/sensor/range", url.Values{"to": {start.Add(-time.Hour).Format(time.RFC3339)}}, http.StatusBadRequest},
+		{"/api/data/temperature/sensor/range", url.Values{"interval": {"-1"}}, http.StatusBadRequest},
+		{"/api/data/temperature/sensor/range", url.Values{"agg": {"p101"}}, http.StatusBadRequest},
+		{"/api/data/temperature/sensor/range", url.Values{"tz": {"Local"}}, http.StatusBadRequest},
+		{"/api/data/temperature/sensor/range", url.Values{"maxPoints": {"1"}}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		values := url.Values{}
+		for key, v := range query {
+			values[key] = v
+		}
+		for key, v := range tt.change {
+			values[key] = v
+		}
+		if rec := get(h, tt.path, values); rec.Code != tt.code {
+			t.Errorf("%s?%s: status %d, want %d", tt.path, values.Encode(), rec.Code, tt.code)
+		}
+	}
+}
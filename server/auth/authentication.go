@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"crypto/subtle"
 	"database/sql"
 	"errors"
 	"log"
@@ -14,6 +15,7 @@ import (
 
 var (
 	databaseHandle *sql.DB = nil
+	adminToken     string
 )
 
 func RegisterDatabase(db *sql.DB) {
@@ -51,6 +53,17 @@ func TokenIsValid(token string) bool {
 	return internal.ContainsValidToken(databaseHandle, token)
 }
 
+// SetAdminToken sets the token authorizing changes to calibrations and the sensor registry.
+// With an empty token no one is authorized to make changes.
+func SetAdminToken(token string) {
+	adminToken = token
+}
+
+// IsAdminToken returns true if token is the admin token.
+func IsAdminToken(token string) bool {
+	return adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
+
 // Generates a new token which will be valid for given dur, or 4 weeks if dur is zero.
 func GenerateToken(dur time.Duration) (string, error) {
 	if dur == 0 {
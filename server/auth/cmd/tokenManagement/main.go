@@ -61,19 +61,12 @@ func handleCreate(reader *bufio.Reader, db *sql.DB) {
 		fmt.Println("error parsing duration:", err)
 		return
 	}
-	now := time.Now()
-	expiryDate := now.Add(dur)
-
-	token, err := auth.GenerateToken()
+	token, err := auth.GenerateToken(dur)
 	if err != nil {
 		fmt.Println("failed to generate token:", err)
 		return
 	}
-
-	if err := internal.InsertToken(db, token, now, expiryDate, false); err != nil {
-		fmt.Println("failed to add token to database:", err)
-		return
-	}
+	fmt.Println("created token:", token)
 }
 
 func handleRevoke(reader *bufio.Reader, db *sql.DB) {
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"github.com/LassiHeikkila/mokki-cloud/server/calibration"
+)
+
+// calibrateValue corrects v of field from sensorID measured at t with its calibration, if any.
+// agg is the aggregate v is the result of, empty for a measured value:
+// counts are not calibrated, and only the gain applies to standard deviations and sums.
+// Derived fields are computed from calibrated inputs, see calibratedInput,
+// and then corrected with their own calibration, if any.
+func calibrateValue(sensorID, field string, t time.Time, v float64, agg string) float64 {
+	entry, ok := calibration.Find(sensorID, field, t)
+	if !ok {
+		return v
+	}
+	switch agg {
+	case "count":
+		return v
+	case "stddev":
+		return math.Abs(entry.Gain) * v
+	case "sum":
+		return entry.Gain * v
+	}
+	return entry.Apply(v)
+}
+
+// calibrate returns m corrected with its calibration, if any. agg is like in calibrateValue.
+func calibrate(m Measurement, agg string) Measurement {
+	v, ok := floatValue(m)
+	if !ok {
+		return m
+	}
+	calibrated := calibrateValue(m.SensorID(), m.Measurement(), m.Time(), v, agg)
+	if calibrated == v {
+		return m
+	}
+	cm, err := NewMeasurement(m.Measurement(), m.SensorID(), calibrated, m.Time())
+	if err != nil {
+		return m
+	}
+	return cm
+}
+
+// calibrateAll returns measured values corrected with their calibrations, see calibrate.
+func calibrateAll(measurements []Measurement) []Measurement {
+	calibrated := make([]Measurement, len(measurements))
+	for i, m := range measurements {
+		calibrated[i] = calibrate(m, "")
+	}
+	return calibrated
+}
+
+// calibratedInput returns a Flux expression of the value of field in record r from one of sensorIDs,
+// calibrated like a measured value in calibrateValue, and the values of its placeholders.
+func calibratedInput(field string, sensorIDs []string) (string, []interface{}) {
+	value := "float(v: r." + field + ")"
+	var (
+		expression = value
+		values     []interface{}
+	)
+	for _, id := range sensorIDs {
+		periods := calibration.Periods(id, field)
+		if periods == nil {
+			continue
+		}
+		var (
+			branches   []string
+			calibrated []interface{}
+		)
+		for _, p := range periods {
+			var branch string
+			if p.To != nil {
+				branch = "if r._time < ? then "
+				calibrated = append(calibrated, *p.To)
+			}
+			if p.Entry == nil {
+				branch += value
+			} else {
+				branch += "? * " + value + " + ?"
+				calibrated = append(calibrated, p.Entry.Gain, p.Entry.Offset)
+			}
+			branches = append(branches, branch)
+		}
+		expression = "if r.sensormac == ? then (" + strings.Join(branches, " else ") + ") else (" + expression + ")"
+		values = append(append([]interface{}{id}, calibrated...), values...)
+	}
+	return expression, values
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/LassiHeikkila/mokki-cloud/server/calibration"
+	"github.com/LassiHeikkila/mokki-cloud/server/flux"
+)
+
+// useCalibrations stores entries in a calibration database of the test.
+func useCalibrations(t *testing.T, entries ...calibration.Entry) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection to :memory: has its own database
+	db.SetMaxOpenConns(1)
+	calibration.RegisterDatabase(db)
+	if err := calibration.InitializeDatabase(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		for _, e := range calibration.Entries() {
+			calibration.Remove(e.ID)
+		}
+		db.Close()
+	})
+	for _, e := range entries {
+		if _, err := calibration.Add(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDerivedFieldsUseCalibratedInputs(t *testing.T) {
+	start := time.Date(2022, 10, 30, 0, 0, 0, 0, time.UTC)
+	calibrated := start.Add(time.Minute)
+	useCalibrations(t, calibration.Entry{SensorID: "sensor", Field: "humidity", Gain: 1, Offset: -4, ValidFrom: &calibrated})
+	store := NewMemoryStore()
+	addSeries(t, store, "temperature", "sensor", []float64{20, 20}, start, time.Minute)
+	addSeries(t, store, "humidity", "sensor", []float64{64, 64}, start, time.Minute)
+	query := RangeQuery{Field: "dewpoint", SensorID: "sensor", Start: start, Stop: start.Add(time.Hour)}
+
+	measurements, _, err := store.Range(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []float64{dewPoint(20, 64), dewPoint(20, 60)}
+	if len(measurements) != len(want) {
+		t.Fatalf("got %d measurements, want %d", len(measurements), len(want))
+	}
+	for i, m := range measurements {
+		if v, _ := floatValue(m); math.Abs(v-want[i]) > 1e-9 {
+			t.Errorf("dew point at %s = %v, want %v", m.Time(), v, want[i])
+		}
+	}
+
+	fluxQuery := filterField(flux.From("bucket"), "dewpoint", []string{"other", "sensor"})
+	if err := fluxQuery.Err(); err != nil {
+		t.Fatal(err)
+	}
+	text := fluxQuery.String()
+	for _, want := range []string{
+		`t = float(v: r.temperature)`,
+		`rh = if r.sensormac == "sensor" then (if r._time < 2022-10-30T00:01:00Z then float(v: r.humidity) else 1.0 * float(v: r.humidity) + -4.0) else (float(v: r.humidity))`,
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("query lacks %s:\n%s", want, text)
+		}
+	}
+}
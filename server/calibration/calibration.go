@@ -0,0 +1,275 @@
+// Package calibration keeps per-sensor, per-field calibrations in the SQLite database
+// and corrects measured values with them.
+package calibration
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/LassiHeikkila/mokki-cloud/server/calibration/internal"
+)
+
+var (
+	databaseHandle *sql.DB = nil
+
+	// entries caches the calibrations table, which is small and read for every value
+	mu      sync.RWMutex
+	entries []Entry
+)
+
+// ErrNotFound is returned when no calibration has the given ID.
+var ErrNotFound = errors.New("calibration not found")
+
+// Entry corrects values of Field from sensor SensorID to Gain * value + Offset.
+// The entry applies to values measured between ValidFrom (inclusive) and ValidTo (exclusive).
+// Nil validity times leave the validity open in that direction.
+type Entry struct {
+	ID        int64      `json:"id"`
+	SensorID  string     `json:"sensorID"`
+	Field     string     `json:"field"`
+	Offset    float64    `json:"offset"`
+	Gain      float64    `json:"gain"`
+	ValidFrom *time.Time `json:"validFrom,omitempty"`
+	ValidTo   *time.Time `json:"validTo,omitempty"`
+}
+
+// Apply returns v corrected with e.
+func (e Entry) Apply(v float64) float64 {
+	return e.Gain*v + e.Offset
+}
+
+func (e Entry) validAt(t time.Time) bool {
+	if e.ValidFrom != nil && t.Before(*e.ValidFrom) {
+		return false
+	}
+	if e.ValidTo != nil && !t.Before(*e.ValidTo) {
+		return false
+	}
+	return true
+}
+
+func (e Entry) validate() error {
+	var err error
+	switch {
+	case e.SensorID == "":
+		err = errors.New("missing sensorID")
+	case e.Field == "":
+		err = errors.New("missing field")
+	case e.Gain == 0:
+		err = errors.New("gain must be non-zero")
+	case e.ValidFrom != nil && e.ValidTo != nil && !e.ValidFrom.Before(*e.ValidTo):
+		err = errors.New("validFrom must be before validTo")
+	}
+	if err != nil {
+		return validationError{err}
+	}
+	return nil
+}
+
+func RegisterDatabase(db *sql.DB) {
+	databaseHandle = db
+}
+
+// InitializeDatabase creates the calibrations table if needed and loads the calibrations.
+func InitializeDatabase() error {
+	if databaseHandle == nil {
+		return errors.New("no database registered")
+	}
+
+	if _, err := databaseHandle.Exec(internal.CalibrationsTableInitStmt); err != nil {
+		return err
+	}
+
+	return reload()
+}
+
+// Entries returns all calibrations.
+func Entries() []Entry {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return append([]Entry(nil), entries...)
+}
+
+// Find returns the calibration of field from sensorID valid at t.
+// If several are valid, the one which became valid last is used.
+func Find(sensorID, field string, t time.Time) (Entry, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return find(sensorID, field, t)
+}
+
+// Changes tells whether the calibration of field from sensorID found at start
+// is replaced, removed or added to at some time within (start, stop).
+func Changes(sensorID, field string, start, stop time.Time) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	initial, initialOK := find(sensorID, field, start)
+	for _, e := range entries {
+		if e.SensorID != sensorID || e.Field != field {
+			continue
+		}
+		for _, t := range []*time.Time{e.ValidFrom, e.ValidTo} {
+			if t == nil || !t.After(start) || !t.Before(stop) {
+				continue
+			}
+			if found, ok := find(sensorID, field, *t); ok != initialOK || found.ID != initial.ID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Period is a time range within which the calibration of a field from a sensor stays the same.
+// Nil From or To leaves the period open in that direction, and nil Entry means no calibration.
+type Period struct {
+	From  *time.Time
+	To    *time.Time
+	Entry *Entry
+}
+
+// Periods splits all time into the periods of the calibrations of field from sensorID, in time order.
+// Nil is returned if the field of the sensor has no calibrations.
+func Periods(sensorID, field string) []Period {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	var (
+		calibrated bool
+		changes    []time.Time
+	)
+	for _, e := range entries {
+		if e.SensorID != sensorID || e.Field != field {
+			continue
+		}
+		calibrated = true
+		for _, t := range []*time.Time{e.ValidFrom, e.ValidTo} {
+			if t != nil {
+				changes = append(changes, *t)
+			}
+		}
+	}
+	if !calibrated {
+		return nil
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Before(changes[j]) })
+
+	entryAt := func(t time.Time) *Entry {
+		if e, ok := find(sensorID, field, t); ok {
+			return &e
+		}
+		return nil
+	}
+	var (
+		periods []Period
+		from    *time.Time
+		// any time before the first change is in the first period
+		entry = entryAt(time.Time{})
+	)
+	for i := range changes {
+		change := changes[i]
+		next := entryAt(change)
+		if sameEntry(entry, next) {
+			continue
+		}
+		periods = append(periods, Period{From: from, To: &change, Entry: entry})
+		from, entry = &change, next
+	}
+	return append(periods, Period{From: from, Entry: entry})
+}
+
+func sameEntry(a, b *Entry) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.ID == b.ID
+}
+
+// find implements Find. Caller must hold the lock.
+func find(sensorID, field string, t time.Time) (Entry, bool) {
+	var (
+		found Entry
+		ok    bool
+	)
+	for _, e := range entries {
+		if e.SensorID != sensorID || e.Field != field || !e.validAt(t) {
+			continue
+		}
+		if !ok || (e.ValidFrom != nil && (found.ValidFrom == nil || e.ValidFrom.After(*found.ValidFrom))) {
+			found, ok = e, true
+		}
+	}
+	return found, ok
+}
+
+// Add stores a new calibration and returns it with its ID.
+func Add(e Entry) (Entry, error) {
+	if err := e.validate(); err != nil {
+		return Entry{}, err
+	}
+	id, err := internal.InsertCalibration(databaseHandle, internal.Row(e))
+	if err != nil {
+		return Entry{}, err
+	}
+	e.ID = id
+	return e, reload()
+}
+
+// Update replaces the calibration with the ID of e.
+func Update(e Entry) error {
+	if err := e.validate(); err != nil {
+		return err
+	}
+	err := internal.UpdateCalibration(databaseHandle, internal.Row(e))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return reload()
+}
+
+// Remove deletes the calibration with id.
+func Remove(id int64) error {
+	err := internal.RemoveCalibration(databaseHandle, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return reload()
+}
+
+// IsValidationError tells whether err was caused by an invalid entry rather than the database.
+func IsValidationError(err error) bool {
+	var v validationError
+	return errors.As(err, &v)
+}
+
+type validationError struct {
+	error
+}
+
+func reload() error {
+	rows, err := internal.GetCalibrations(databaseHandle)
+	if err != nil {
+		return err
+	}
+	loaded := make([]Entry, 0, len(rows))
+	for _, row := range rows {
+		loaded = append(loaded, Entry(row))
+	}
+
+	mu.Lock()
+	entries = loaded
+	mu.Unlock()
+	return nil
+}
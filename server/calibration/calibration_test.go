@@ -0,0 +1,92 @@
+package calibration
+
+import (
+	"testing"
+	"time"
+)
+
+func at(day int) *time.Time {
+	t := time.Date(2022, 10, day, 0, 0, 0, 0, time.UTC)
+	return &t
+}
+
+func useTestEntries(t *testing.T) {
+	t.Helper()
+	entries = []Entry{
+		{ID: 1, SensorID: "a", Field: "temperature", Gain: 1, Offset: -0.5, ValidTo: at(10)},
+		{ID: 2, SensorID: "a", Field: "temperature", Gain: 1, Offset: -0.8, ValidFrom: at(10)},
+		{ID: 3, SensorID: "a", Field: "humidity", Gain: 1, Offset: 2, ValidFrom: at(5), ValidTo: at(15)},
+		// overridden by entry 2 whenever valid
+		{ID: 4, SensorID: "a", Field: "temperature", Gain: 1, Offset: 1, ValidFrom: at(1), ValidTo: at(20)},
+	}
+	t.Cleanup(func() {
+		entries = nil
+	})
+}
+
+func TestChanges(t *testing.T) {
+	useTestEntries(t)
+
+	tests := []struct {
+		field       string
+		start, stop *time.Time
+		want        bool
+	}{
+		{"temperature", at(11), at(25), false},
+		{"temperature", at(5), at(11), true},
+		{"temperature", at(10), at(11), false},
+		{"temperature", at(5), at(10), false},
+		{"temperature", at(0), at(5), true},
+		{"humidity", at(1), at(5), false},
+		{"humidity", at(1), at(6), true},
+		{"humidity", at(6), at(15), false},
+		{"humidity", at(6), at(16), true},
+		{"pressure", at(1), at(30), false},
+	}
+	for _, tt := range tests {
+		if got := Changes("a", tt.field, *tt.start, *tt.stop); got != tt.want {
+			t.Errorf("Changes(%s, %s, %s) = %t, want %t", tt.field, tt.start, tt.stop, got, tt.want)
+		}
+	}
+}
+
+func TestPeriods(t *testing.T) {
+	useTestEntries(t)
+
+	tests := []struct {
+		field string
+		want  []Period
+	}{
+		{"temperature", []Period{
+			{To: at(1), Entry: &entries[0]},
+			{From: at(1), To: at(10), Entry: &entries[3]},
+			{From: at(10), Entry: &entries[1]},
+		}},
+		{"humidity", []Period{
+			{To: at(5)},
+			{From: at(5), To: at(15), Entry: &entries[2]},
+			{From: at(15)},
+		}},
+		{"pressure", nil},
+	}
+	for _, tt := range tests {
+		got := Periods("a", tt.field)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %d periods, want %d", tt.field, len(got), len(tt.want))
+			continue
+		}
+		for i, p := range got {
+			want := tt.want[i]
+			if !sameTime(p.From, want.From) || !sameTime(p.To, want.To) || !sameEntry(p.Entry, want.Entry) {
+				t.Errorf("%s: period %d is %+v, want %+v", tt.field, i, p, want)
+			}
+		}
+	}
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
@@ -0,0 +1,172 @@
+package internal
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Row is one row of the calibrations table.
+// Nil validity times leave the validity open in that direction.
+type Row struct {
+	ID        int64
+	SensorID  string
+	Field     string
+	Offset    float64
+	Gain      float64
+	ValidFrom *time.Time
+	ValidTo   *time.Time
+}
+
+func InsertCalibration(db *sql.DB, row Row) (int64, error) {
+	if db == nil {
+		return 0, errors.New("no database registered")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT INTO calibrations
+		(sensorID, field, offsetValue, gain, validFrom, validTo)
+		VALUES (?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.Exec(row.SensorID, row.Field, row.Offset, row.Gain, formatTime(row.ValidFrom), formatTime(row.ValidTo))
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func UpdateCalibration(db *sql.DB, row Row) error {
+	if db == nil {
+		return errors.New("no database registered")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`UPDATE calibrations
+		SET sensorID = ?, field = ?, offsetValue = ?, gain = ?, validFrom = ?, validTo = ?
+		WHERE id = ?`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.Exec(row.SensorID, row.Field, row.Offset, row.Gain, formatTime(row.ValidFrom), formatTime(row.ValidTo), row.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func RemoveCalibration(db *sql.DB, id int64) error {
+	if db == nil {
+		return errors.New("no database registered")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`DELETE FROM calibrations
+		WHERE id == ?`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.Exec(id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func GetCalibrations(db *sql.DB) ([]Row, error) {
+	if db == nil {
+		return nil, errors.New("no database registered")
+	}
+
+	rows, err := db.Query(`SELECT id, sensorID, field, offsetValue, gain, validFrom, validTo FROM calibrations
+		ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var calibrations []Row
+	for rows.Next() {
+		var (
+			row                Row
+			validFrom, validTo sql.NullString
+		)
+		if err := rows.Scan(&row.ID, &row.SensorID, &row.Field, &row.Offset, &row.Gain, &validFrom, &validTo); err != nil {
+			return nil, err
+		}
+		if row.ValidFrom, err = parseTime(validFrom); err != nil {
+			return nil, err
+		}
+		if row.ValidTo, err = parseTime(validTo); err != nil {
+			return nil, err
+		}
+		calibrations = append(calibrations, row)
+	}
+
+	return calibrations, rows.Err()
+}
+
+func formatTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func parseTime(s sql.NullString) (*time.Time, error) {
+	if !s.Valid {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s.String)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
@@ -0,0 +1,15 @@
+package internal
+
+const CalibrationsTableInitStmt = `
+CREATE TABLE IF NOT EXISTS "calibrations"
+(
+	id INTEGER NOT NULL,
+	sensorID TEXT NOT NULL,
+	field TEXT NOT NULL,
+	offsetValue REAL NOT NULL DEFAULT 0,
+	gain REAL NOT NULL DEFAULT 1,
+	validFrom TEXT,
+	validTo TEXT,
+	PRIMARY KEY (id)
+);
+`
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/LassiHeikkila/mokki-cloud/server/calibration"
+	"github.com/LassiHeikkila/mokki-cloud/server/registry"
+)
+
+// HandleCalibrations lists calibrations on GET and adds one on POST.
+// Adding requires the admin token.
+func HandleCalibrations(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	if !Authenticated(w, req) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	switch req.Method {
+	case http.MethodGet:
+		writeJSON(w, calibration.Entries())
+	case http.MethodPost:
+		if !IsAdmin(req) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		entry, err := readCalibration(req)
+		if err != nil {
+			log.Println("error reading calibration from request:", err)
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		entry, err = calibration.Add(entry)
+		if err != nil {
+			writeCalibrationError(w, err)
+			return
+		}
+		b, err := json.Marshal(entry)
+		if err != nil {
+			log.Println("error marshalling data:", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write(b)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleCalibration replaces a calibration on PUT and removes it on DELETE,
+// both of which require the admin token.
+func HandleCalibration(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	if !Authenticated(w, req) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := getCalibrationIDFromPath(req.URL.Path)
+	if err != nil {
+		log.Printf("error getting calibration id from request path (%s): %s\n", req.URL.Path, err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if req.Method == http.MethodPut || req.Method == http.MethodDelete {
+		if !IsAdmin(req) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+	}
+	switch req.Method {
+	case http.MethodPut:
+		entry, err := readCalibration(req)
+		if err != nil {
+			log.Println("error reading calibration from request:", err)
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		entry.ID = id
+		if err := calibration.Update(entry); err != nil {
+			writeCalibrationError(w, err)
+			return
+		}
+		writeJSON(w, entry)
+	case http.MethodDelete:
+		if err := calibration.Remove(id); err != nil {
+			writeCalibrationError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// readCalibration reads a calibration from the request body. Gain defaults to 1.
+// The sensor may be given by its alias.
+func readCalibration(req *http.Request) (calibration.Entry, error) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return calibration.Entry{}, err
+	}
+	entry := calibration.Entry{Gain: 1}
+	if err := json.Unmarshal(b, &entry); err != nil {
+		return calibration.Entry{}, err
+	}
+	entry.SensorID = registry.Resolve(entry.SensorID)
+	return entry, nil
+}
+
+func writeCalibrationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, calibration.ErrNotFound):
+		http.Error(w, "calibration not found", http.StatusNotFound)
+	case calibration.IsValidationError(err):
+		log.Println("invalid calibration:", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+	default:
+		log.Println("error storing calibration:", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+	}
+}
+
+func getCalibrationIDFromPath(path string) (int64, error) {
+	// /api/calibrations/{id}
+	// id is third item, but split counts the empty value before the first /
+	segments := strings.Split(path, "/")
+	if len(segments) != 4 {
+		return 0, fmt.Errorf("malformed path: %v", segments)
+	}
+	return strconv.ParseInt(segments[3], 10, 64)
+}
@@ -11,6 +11,7 @@ type InfluxDBConfig struct {
 	AuthToken    string `json:"token"`
 	Bucket       string `json:"bucket"`
 	Measurement  string `json:"measurement"`
+	UseParams    bool   `json:"params"`
 }
 
 func loadConfig(file string, v interface{}) error {
@@ -11,6 +11,7 @@ import (
 	"os/signal"
 	"strings"
 	"time"
+	_ "time/tzdata"
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
@@ -18,12 +19,15 @@ import (
 
 	"github.com/LassiHeikkila/mokki-cloud/server"
 	"github.com/LassiHeikkila/mokki-cloud/server/auth"
+	"github.com/LassiHeikkila/mokki-cloud/server/calibration"
+	"github.com/LassiHeikkila/mokki-cloud/server/registry"
 )
 
 const applicationVersion = "0.1.0"
 
 const (
 	allowedCORSOriginsEnvKey = "CORSORIGINS"
+	adminTokenEnvKey         = "ADMINTOKEN"
 )
 
 var (
@@ -45,8 +49,13 @@ func main() {
 		key  = flag.String("key", "", "Path to private TLS key file")
 
 		influxDBConfigFile = flag.String("influxDBConfig", "influxdb.json", "Path to config JSON containing InfluxDB parameters")
+		memoryStore        = flag.Bool("memoryStore", false, "Serve data from an in-memory store instead of InfluxDB")
+		fieldsConfigFile   = flag.String("fieldsConfig", "", "Path to config JSON declaring additional fields to serve")
+		boundsConfigFile   = flag.String("boundsConfig", "", "Path to config JSON overriding plausible values of fields")
 
 		authDB = flag.String("authdb", "auth.db", "Path to authentication database")
+
+		timezone = flag.String("timezone", "UTC", "Default IANA time zone for daily and longer aggregation windows, e.g. Europe/Helsinki")
 	)
 	flag.Parse()
 
@@ -63,6 +72,8 @@ func main() {
 		return
 	}
 
+	auth.SetAdminToken(os.Getenv(adminTokenEnvKey))
+
 	if *authDB != "" {
 		db, err := sql.Open("sqlite3", *authDB)
 		if err != nil {
@@ -76,49 +87,101 @@ func main() {
 			log.Println("failed to initialize database", err)
 			return
 		}
+
+		calibration.RegisterDatabase(db)
+		if err := calibration.InitializeDatabase(); err != nil {
+			log.Println("failed to initialize calibrations", err)
+			return
+		}
+
+		registry.RegisterDatabase(db)
+		if err := registry.InitializeDatabase(); err != nil {
+			log.Println("failed to initialize sensor registry", err)
+			return
+		}
 	}
 
-	var influxConfig InfluxDBConfig
-	err := loadConfig(*influxDBConfigFile, &influxConfig)
+	location, err := server.LoadLocation(*timezone)
 	if err != nil {
-		log.Println("error loading influxdb config:", err)
+		log.Println("error loading time zone:", err)
 		return
 	}
 
-	q := server.NewQuerier(
-		influxConfig.Address,
-		influxConfig.AuthToken,
-		influxConfig.Organization,
-	)
-	defer q.Close()
-
-	bucket := influxConfig.Bucket
-	measurement := influxConfig.Measurement
+	if *fieldsConfigFile != "" {
+		var fieldTypes []server.FieldType
+		if err := loadConfig(*fieldsConfigFile, &fieldTypes); err != nil {
+			log.Println("error loading fields config:", err)
+			return
+		}
+		for _, ft := range fieldTypes {
+			if err := server.RegisterFieldType(ft); err != nil {
+				log.Println("error registering field:", err)
+				return
+			}
+		}
+	}
 
-	server.QueryLatest = func(
-		ctx context.Context,
-		field string,
-		id string,
-	) server.Measurement {
-		return q.QueryLastValue(ctx, bucket, field, id, measurement)
+	if *boundsConfigFile != "" {
+		var bounds map[string]server.Bounds
+		if err := loadConfig(*boundsConfigFile, &bounds); err != nil {
+			log.Println("error loading bounds config:", err)
+			return
+		}
+		for field, b := range bounds {
+			if err := server.SetFieldBounds(field, b); err != nil {
+				log.Println("error in bounds config:", err)
+				return
+			}
+		}
 	}
-	server.QueryTimeRange = func(
-		ctx context.Context,
-		field string,
-		id string,
-		start time.Time,
-		stop time.Time,
-		interval time.Duration,
-	) []server.Measurement {
-		return q.QueryBetweenTimes(ctx, bucket, field, id, measurement, start, stop, interval)
+
+	var store server.Store
+	if *memoryStore {
+		store = server.NewMemoryStore()
+	} else {
+		var influxConfig InfluxDBConfig
+		err := loadConfig(*influxDBConfigFile, &influxConfig)
+		if err != nil {
+			log.Println("error loading influxdb config:", err)
+			return
+		}
+
+		q := server.NewQuerier(
+			influxConfig.Address,
+			influxConfig.AuthToken,
+			influxConfig.Organization,
+			influxConfig.Bucket,
+			influxConfig.Measurement,
+		)
+		defer q.Close()
+		q.UseParams(influxConfig.UseParams)
+
+		store = q
 	}
 
+	h := server.NewHandler(store, location)
+
 	r := mux.NewRouter()
 	r.HandleFunc("/", server.HandleRoot)
 	r.HandleFunc("/api/authorize", server.HandleAuthorization)
 	r.HandleFunc("/api/checkToken", server.HandleCheckToken)
-	r.HandleFunc("/api/data/{field}/{id}/latest", server.HandleLatest)
-	r.HandleFunc("/api/data/{field}/{id}/range", server.HandleRange)
+	r.HandleFunc("/api/calibrations", server.HandleCalibrations)
+	r.HandleFunc("/api/calibrations/{id}", server.HandleCalibration)
+	r.HandleFunc("/api/data/{field}/{id}/latest", h.HandleLatest)
+	r.HandleFunc("/api/data/{field}/{id}/range", h.HandleRange)
+	r.HandleFunc("/api/data/{field}/{id}/stats", h.HandleStats)
+	r.HandleFunc("/api/data/{field}/{id}/compare", h.HandleCompare)
+	r.HandleFunc("/api/degreedays/{id}", h.HandleDegreeDays)
+	r.HandleFunc("/api/groups", server.HandleGroups)
+	r.HandleFunc("/api/groups/{group}", server.HandleGroup)
+	r.HandleFunc("/api/groups/{group}/{field}/range", h.HandleGroupRange)
+	r.HandleFunc("/api/mould/{id}", h.HandleMould)
+	r.HandleFunc("/api/registry", server.HandleRegistry)
+	r.HandleFunc("/api/registry/{id}", server.HandleRegistrySensor)
+	r.HandleFunc("/api/snapshot", h.HandleSnapshot)
+	r.HandleFunc("/api/sensors", h.HandleSensors)
+	r.HandleFunc("/api/sensors/status", h.HandleSensorStatus)
+	r.HandleFunc("/api/sensors/{id}/fields", h.HandleSensorFields)
 
 	// CORS handling courtesy of:
 	// https://stackoverflow.com/a/40987389/13580269
@@ -137,12 +200,13 @@ func main() {
 		http.MethodDelete,
 		http.MethodOptions,
 	})
+	exposedOK := handlers.ExposedHeaders(server.ExposedHeaders)
 	credentialsOK := handlers.AllowCredentials()
 	const dir = "www"
 	r.PathPrefix("/").Handler(http.StripPrefix("/", http.FileServer(http.Dir(dir))))
 	handler := handlers.CombinedLoggingHandler(
 		log.Writer(),
-		handlers.CORS(originsOK, headersOK, methodsOK, credentialsOK)(r),
+		handlers.CORS(originsOK, headersOK, methodsOK, exposedOK, credentialsOK)(r),
 	)
 
 	server := http.Server{
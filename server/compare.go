@@ -0,0 +1,245 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxComparisonOffsets limits the number of periods compared in one request.
+const maxComparisonOffsets = 10
+
+var periodOffsetRegexp = regexp.MustCompile(`^(\d+)(y|mo|w|d|h|m|s)`)
+
+// PeriodOffset shifts a period by calendar years and months and by a fixed duration,
+// e.g. "-1y" to the same dates a year earlier or "-7d" to the previous week.
+type PeriodOffset struct {
+	text     string
+	years    int
+	months   int
+	duration time.Duration
+}
+
+// ParsePeriodOffset parses offsets like "-1y", "-6mo", "-7d" or "+1w3d".
+// Years and months are calendar years and months, see addMonths, other units fixed durations as in parseRelativeDuration.
+func ParsePeriodOffset(value string) (PeriodOffset, error) {
+	if len(value) < 2 || (value[0] != '-' && value[0] != '+') {
+		return PeriodOffset{}, fmt.Errorf("offset must start with - or +: %s", value)
+	}
+	sign := 1
+	if value[0] == '-' {
+		sign = -1
+	}
+	var (
+		years, months int64
+		duration      time.Duration
+	)
+	for rest := value[1:]; rest != ""; {
+		match := periodOffsetRegexp.FindStringSubmatch(rest)
+		if match == nil {
+			return PeriodOffset{}, fmt.Errorf("invalid offset: %s", value)
+		}
+		n, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return PeriodOffset{}, err
+		}
+		switch match[2] {
+		case "y":
+			years += n
+		case "mo":
+			months += n
+		default:
+			unit := relativeDurationUnits[match[2]]
+			if n > math.MaxInt64/int64(unit) || time.Duration(n)*unit > math.MaxInt64-duration {
+				return PeriodOffset{}, fmt.Errorf("offset too long: %s", value)
+			}
+			duration += time.Duration(n) * unit
+		}
+		// keeps the months added up in addMonths well within int
+		if years > math.MaxInt32/12 || months > math.MaxInt32-12*years {
+			return PeriodOffset{}, fmt.Errorf("offset too long: %s", value)
+		}
+		rest = rest[len(match[0]):]
+	}
+	if years == 0 && months == 0 && duration == 0 {
+		return PeriodOffset{}, fmt.Errorf("offset must not be zero: %s", value)
+	}
+	return PeriodOffset{
+		text:     value,
+		years:    sign * int(years),
+		months:   sign * int(months),
+		duration: time.Duration(sign) * duration,
+	}, nil
+}
+
+func (o PeriodOffset) String() string {
+	return o.text
+}
+
+// shift moves t by the offset.
+func (o PeriodOffset) shift(t time.Time) time.Time {
+	return addMonths(t, 12*o.years+o.months).Add(o.duration)
+}
+
+// unshift moves t shifted by the offset back onto the base period.
+func (o PeriodOffset) unshift(t time.Time) time.Time {
+	return addMonths(t.Add(-o.duration), -12*o.years-o.months)
+}
+
+// addMonths adds months to t like AddDate, except that a day beyond the end of the resulting month
+// is clamped to its last day instead of overflowing into the next month,
+// e.g. one month before March 31 is February 28, or 29 in leap years, rather than March 3.
+func addMonths(t time.Time, months int) time.Time {
+	year, month, day := t.Date()
+	first := time.Date(year, month+time.Month(months), 1, 0, 0, 0, 0, t.Location())
+	if last := first.AddDate(0, 1, -1).Day(); day > last {
+		day = last
+	}
+	hour, min, sec := t.Clock()
+	return time.Date(first.Year(), first.Month(), day, hour, min, sec, t.Nanosecond(), t.Location())
+}
+
+// Comparison holds the same range of a field over a base period and periods offset from it.
+// The first period is the base period.
+type Comparison struct {
+	SensorID string           `json:"sensorID"`
+	Field    string           `json:"field"`
+	Unit     string           `json:"unit"`
+	Periods  []ComparedPeriod `json:"periods"`
+}
+
+// ComparedPeriod is one period of a Comparison. Start and Stop are the actual times queried,
+// while the times of Measurements are realigned onto the base period so that series can be plotted together.
+// Summary is nil if the period has no data, or if it cannot be computed, in which case
+// SummaryUnavailable tells why, e.g. when the calibration of the field changes within the period.
+type ComparedPeriod struct {
+	Offset             string         `json:"offset"`
+	Start              time.Time      `json:"start"`
+	Stop               time.Time      `json:"stop"`
+	Measurements       []Measurement  `json:"measurements"`
+	Summary            *PeriodSummary `json:"summary"`
+	SummaryUnavailable string         `json:"summaryUnavailable,omitempty"`
+}
+
+// PeriodSummary summarises the raw values of a period.
+// The deltas are the differences to the base period, given when both periods have data.
+type PeriodSummary struct {
+	Count     int      `json:"count"`
+	Mean      float64  `json:"mean"`
+	Min       float64  `json:"min"`
+	Max       float64  `json:"max"`
+	MeanDelta *float64 `json:"meanDelta,omitempty"`
+	MinDelta  *float64 `json:"minDelta,omitempty"`
+	MaxDelta  *float64 `json:"maxDelta,omitempty"`
+}
+
+// compareRange runs query over its own period and over each offset period.
+// ErrNoData is returned only if none of the periods have data.
+// The number of values left out as implausible in all of the periods is returned too.
+func compareRange(ctx context.Context, store Store, query RangeQuery, offsets []PeriodOffset) (Comparison, int, error) {
+	// offset periods need explicit times, so the base period uses them too
+	query.Since = 0
+
+	comparison := Comparison{
+		SensorID: query.SensorID,
+		Field:    query.Field,
+	}
+	base, rejected, err := comparePeriod(ctx, store, query, PeriodOffset{text: "0"})
+	if err != nil {
+		return Comparison{}, 0, err
+	}
+	comparison.Periods = append(comparison.Periods, base)
+	for _, offset := range offsets {
+		period, n, err := comparePeriod(ctx, store, query, offset)
+		if err != nil {
+			return Comparison{}, 0, err
+		}
+		rejected += n
+		if period.Summary != nil && base.Summary != nil {
+			meanDelta := period.Summary.Mean - base.Summary.Mean
+			minDelta := period.Summary.Min - base.Summary.Min
+			maxDelta := period.Summary.Max - base.Summary.Max
+			period.Summary.MeanDelta = &meanDelta
+			period.Summary.MinDelta = &minDelta
+			period.Summary.MaxDelta = &maxDelta
+		}
+		comparison.Periods = append(comparison.Periods, period)
+	}
+
+	for _, period := range comparison.Periods {
+		if period.Summary != nil || len(period.Measurements) > 0 {
+			return comparison, rejected, nil
+		}
+	}
+	return Comparison{}, 0, ErrNoData
+}
+
+// comparePeriod queries the period of query shifted by offset and realigns it onto the period of query.
+// The number of values left out as implausible is returned too.
+func comparePeriod(ctx context.Context, store Store, query RangeQuery, offset PeriodOffset) (ComparedPeriod, int, error) {
+	query.Start = offset.shift(query.Start)
+	query.Stop = offset.shift(query.Stop)
+	period := ComparedPeriod{
+		Offset:       offset.String(),
+		Start:        query.Start,
+		Stop:         query.Stop,
+		Measurements: []Measurement{},
+	}
+
+	measurements, rejected, err := store.Range(ctx, query)
+	if errors.Is(err, ErrNoData) {
+		return period, 0, nil
+	}
+	if err != nil {
+		return ComparedPeriod{}, 0, err
+	}
+	for _, m := range measurements {
+		period.Measurements = append(period.Measurements, asMeasurement(m).withTime(offset.unshift(m.Time())))
+	}
+
+	stats, err := store.Stats(ctx, query)
+	if errors.Is(err, ErrNoData) {
+		return period, rejected, nil
+	}
+	if errors.Is(err, ErrCalibrationChanged) {
+		period.SummaryUnavailable = err.Error()
+		return period, rejected, nil
+	}
+	if err != nil {
+		return ComparedPeriod{}, 0, err
+	}
+	min, _ := floatValue(stats.Min)
+	max, _ := floatValue(stats.Max)
+	period.Summary = &PeriodSummary{
+		Count: stats.Count,
+		Mean:  stats.Mean,
+		Min:   min,
+		Max:   max,
+	}
+	return period, rejected, nil
+}
+
+// parsePeriodOffsets parses a comma separated list of offsets.
+func parsePeriodOffsets(value string) ([]PeriodOffset, error) {
+	if value == "" {
+		return nil, errors.New("no offsets given")
+	}
+	parts := strings.Split(value, ",")
+	if len(parts) > maxComparisonOffsets {
+		return nil, fmt.Errorf("at most %d offsets can be compared", maxComparisonOffsets)
+	}
+	offsets := make([]PeriodOffset, 0, len(parts))
+	for _, part := range parts {
+		offset, err := ParsePeriodOffset(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		offsets = append(offsets, offset)
+	}
+	return offsets, nil
+}
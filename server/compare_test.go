@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPeriodOffsetShiftClampsToMonthEnd(t *testing.T) {
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 12, 30, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		offset string
+		t      time.Time
+		want   time.Time
+	}{
+		{"-1mo", date(2022, time.March, 31), date(2022, time.February, 28)},
+		{"-1mo", date(2024, time.March, 31), date(2024, time.February, 29)},
+		{"-1y", date(2024, time.February, 29), date(2023, time.February, 28)},
+		{"+1mo", date(2022, time.January, 31), date(2022, time.February, 28)},
+		{"-6mo", date(2022, time.August, 31), date(2022, time.February, 28)},
+		{"-1y1mo", date(2022, time.December, 31), date(2021, time.November, 30)},
+		{"+11mo", date(2022, time.March, 15), date(2023, time.February, 15)},
+		{"-1mo1d", date(2022, time.March, 31), date(2022, time.February, 27)},
+	}
+	for _, tt := range tests {
+		offset, err := ParsePeriodOffset(tt.offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := offset.shift(tt.t); !got.Equal(tt.want) {
+			t.Errorf("%s shifts %s to %s, want %s", tt.offset, tt.t, got, tt.want)
+		}
+	}
+}
+
+func TestPeriodOffsetShiftKeepsLocalTime(t *testing.T) {
+	helsinki := loadLocation(t, "Europe/Helsinki")
+	offset, err := ParsePeriodOffset("-1y")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2022, time.October, 31, 0, 0, 0, 0, helsinki)
+	want := time.Date(2021, time.October, 31, 0, 0, 0, 0, helsinki)
+	if got := offset.shift(start); !got.Equal(want) {
+		t.Errorf("shift(%s) = %s, want %s", start, got, want)
+	}
+	if got := offset.unshift(want); !got.Equal(start) {
+		t.Errorf("unshift(%s) = %s, want %s", want, got, start)
+	}
+}
+
+func TestParsePeriodOffset(t *testing.T) {
+	valid := map[string]time.Duration{
+		"-1y":    0,
+		"+6mo":   0,
+		"-7d":    -7 * day,
+		"+1w3d":  10 * day,
+		"-1y12h": -12 * time.Hour,
+	}
+	for value, want := range valid {
+		offset, err := ParsePeriodOffset(value)
+		if err != nil {
+			t.Errorf("ParsePeriodOffset(%q): %v", value, err)
+			continue
+		}
+		if offset.duration != want {
+			t.Errorf("ParsePeriodOffset(%q) has duration %s, want %s", value, offset.duration, want)
+		}
+	}
+
+	for _, value := range []string{
+		"",
+		"1y",
+		"-",
+		"-0d",
+		"-1x",
+		"-106752d",
+		"-15251w",
+		"-9223372036854775807s",
+		"-15250w15250w",
+		"-9223372036854775808s",
+		"-99999999999999999999s",
+		"-178956971y",
+		"-2147483648mo",
+		"-1y2147483647mo",
+	} {
+		if _, err := ParsePeriodOffset(value); err == nil {
+			t.Errorf("ParsePeriodOffset(%q) was accepted", value)
+		}
+	}
+}
+
+// calibrationChangingStore is a Store whose calibration changes at changedAt.
+type calibrationChangingStore struct {
+	Store
+	changedAt time.Time
+}
+
+func (s calibrationChangingStore) Stats(ctx context.Context, query RangeQuery) (Stats, error) {
+	if query.Start.Before(s.changedAt) && query.Stop.After(s.changedAt) {
+		return Stats{}, ErrCalibrationChanged
+	}
+	return s.Store.Stats(ctx, query)
+}
+
+func TestCompareRangeKeepsPeriodsAroundCalibrationChange(t *testing.T) {
+	start := time.Date(2022, 10, 30, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	addSeries(t, store, "temperature", "sensor", []float64{20, 21, 22, 23}, start.Add(-7*day), 12*time.Hour)
+	addSeries(t, store, "temperature", "sensor", []float64{18, 19}, start, 12*time.Hour)
+	query := RangeQuery{Field: "temperature", SensorID: "sensor", Start: start, Stop: start.Add(day)}
+	offset, err := ParsePeriodOffset("-7d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	changing := calibrationChangingStore{Store: store, changedAt: start.Add(-6*day - time.Hour)}
+
+	comparison, _, err := compareRange(context.Background(), changing, query, []PeriodOffset{offset})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(comparison.Periods) != 2 {
+		t.Fatalf("got %d periods, want 2", len(comparison.Periods))
+	}
+	base, previous := comparison.Periods[0], comparison.Periods[1]
+	if base.Summary == nil || base.Summary.Mean != 18.5 || base.SummaryUnavailable != "" {
+		t.Errorf("base period summary = %+v (%q), want mean 18.5", base.Summary, base.SummaryUnavailable)
+	}
+	if previous.Summary != nil || previous.SummaryUnavailable == "" || len(previous.Measurements) != 2 {
+		t.Errorf("offset period has summary %+v (%q) and %d measurements, want none, a reason and 2",
+			previous.Summary, previous.SummaryUnavailable, len(previous.Measurements))
+	}
+}
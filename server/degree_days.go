@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const (
+	// defaultHeatingBase is the Finnish convention for heating degree days
+	defaultHeatingBase = 17.0
+
+	// freezingBase is the base temperature of freezing degree days
+	freezingBase = 0.0
+)
+
+// DegreeDays holds heating and freezing degree days of a sensor, grouped by period.
+// Base and the degree days are in Unit, the unit of temperature of the request.
+type DegreeDays struct {
+	SensorID      string             `json:"sensorID"`
+	Unit          string             `json:"unit"`
+	Base          float64            `json:"base"`
+	Period        string             `json:"period"`
+	Periods       []DegreeDaysPeriod `json:"periods"`
+	HeatingTotal  float64            `json:"heatingTotal"`
+	FreezingTotal float64            `json:"freezingTotal"`
+}
+
+// DegreeDaysPeriod holds the degree days of one day, month or season.
+type DegreeDaysPeriod struct {
+	Start              time.Time `json:"start"`
+	End                time.Time `json:"end"`
+	Days               int       `json:"days"`
+	Heating            float64   `json:"heating"`
+	Freezing           float64   `json:"freezing"`
+	CumulativeHeating  float64   `json:"cumulativeHeating"`
+	CumulativeFreezing float64   `json:"cumulativeFreezing"`
+}
+
+// periodStart returns the start of the day, month or season containing day.
+// Seasons run from July to June, so that each contains one whole winter.
+func periodStart(period string, day time.Time) (time.Time, error) {
+	switch period {
+	case "day":
+		return day, nil
+	case "month":
+		return time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, day.Location()), nil
+	case "season":
+		year := day.Year()
+		if day.Month() < time.July {
+			year--
+		}
+		return time.Date(year, time.July, 1, 0, 0, 0, 0, day.Location()), nil
+	}
+	return time.Time{}, errors.New("unknown period: " + period)
+}
+
+func periodEnd(period string, start time.Time) time.Time {
+	switch period {
+	case "month":
+		return start.AddDate(0, 1, 0)
+	case "season":
+		return start.AddDate(1, 0, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+// computeDegreeDays computes degree days from the daily mean temperatures of query in the units of temperature in units.
+// Heating degree days of a day are base minus its mean temperature, and freezing degree days
+// are the mean temperature below the freezing point, both zero if the day was warmer.
+// Days at the edges of the range only include the measurements within the range.
+func computeDegreeDays(ctx context.Context, store Store, units Units, query RangeQuery, base float64, period string) (DegreeDays, error) {
+	if _, err := periodStart(period, time.Time{}); err != nil {
+		return DegreeDays{}, err
+	}
+
+	query.Field = "temperature"
+	query.Interval = day
+	query.Months = 0
+	query.Aggregate = AggregateMean
+	if query.Location == nil {
+		query.Location = time.UTC
+	}
+	dailyMeans, _, err := withUnits(store, units).Range(ctx, query)
+	if err != nil {
+		return DegreeDays{}, err
+	}
+
+	freezingPoint := units.convertValue(query.Field, freezingBase, "")
+	result := DegreeDays{
+		SensorID: query.SensorID,
+		Unit:     units.unit(query.Field),
+		Base:     base,
+		Period:   period,
+	}
+	for _, m := range dailyMeans {
+		mean, ok := floatValue(m)
+		if !ok {
+			continue
+		}
+		// daily windows are stamped with the midnight ending them
+		end := m.Time().In(query.Location)
+		day := startOfDay(end.Add(-time.Nanosecond))
+		start, err := periodStart(period, day)
+		if err != nil {
+			return DegreeDays{}, err
+		}
+
+		if n := len(result.Periods); n == 0 || !result.Periods[n-1].Start.Equal(start) {
+			result.Periods = append(result.Periods, DegreeDaysPeriod{
+				Start: start,
+				End:   periodEnd(period, start),
+			})
+		}
+		p := &result.Periods[len(result.Periods)-1]
+		heating := positivePart(base - mean)
+		freezing := positivePart(freezingPoint - mean)
+		p.Days++
+		p.Heating += heating
+		p.Freezing += freezing
+		result.HeatingTotal += heating
+		result.FreezingTotal += freezing
+		p.CumulativeHeating = result.HeatingTotal
+		p.CumulativeFreezing = result.FreezingTotal
+	}
+
+	if len(result.Periods) == 0 {
+		return DegreeDays{}, ErrNoData
+	}
+	return result, nil
+}
+
+func positivePart(v float64) float64 {
+	if v < 0 {
+		return 0
+	}
+	return v
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDegreeDaysInUnits(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	addSeries(t, store, "temperature", "sensor", []float64{8, 12, -4, -6}, start, 12*time.Hour)
+	query := RangeQuery{SensorID: "sensor", Start: start, Stop: start.Add(2 * day)}
+
+	tests := []struct {
+		units             string
+		unit              string
+		heating, freezing float64
+		base              float64
+	}{
+		{"", "°C", 7 + 22, 5, 17},
+		{"temperature=K", "K", 7 + 22, 5, 290.15},
+		{"imperial", "°F", 1.8 * (7 + 22), 1.8 * 5, 62.6},
+	}
+	for _, tt := range tests {
+		units, err := ParseUnits(tt.units)
+		if err != nil {
+			t.Fatal(err)
+		}
+		base := units.convertValue("temperature", defaultHeatingBase, "")
+		if math.Abs(base-tt.base) > 1e-9 {
+			t.Errorf("%s: default base is %v, want %v", tt.units, base, tt.base)
+		}
+		data, err := computeDegreeDays(context.Background(), store, units, query, base, "season")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data.Unit != tt.unit {
+			t.Errorf("%s: unit is %q, want %q", tt.units, data.Unit, tt.unit)
+		}
+		if math.Abs(data.HeatingTotal-tt.heating) > 1e-9 || math.Abs(data.FreezingTotal-tt.freezing) > 1e-9 {
+			t.Errorf("%s: got %v heating and %v freezing degree days, want %v and %v",
+				tt.units, data.HeatingTotal, data.FreezingTotal, tt.heating, tt.freezing)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package server
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/LassiHeikkila/mokki-cloud/server/flux"
+)
+
+// derivedInput is a field a derived field is computed from,
+// bound to variable in the Flux expression of the derived field.
+type derivedInput struct {
+	field    string
+	variable string
+}
+
+// climateInputs are the inputs of fields computed from temperature t in Celsius
+// and relative humidity rh in percent.
+var climateInputs = []derivedInput{{field: "temperature", variable: "t"}, {field: "humidity", variable: "rh"}}
+
+// accelerationInputs are the inputs of fields computed from the acceleration along each axis.
+var accelerationInputs = []derivedInput{
+	{field: "accelerationX", variable: "x"},
+	{field: "accelerationY", variable: "y"},
+	{field: "accelerationZ", variable: "z"},
+}
+
+// derivedField is a field computed from other fields measured by the same sensor at the same time.
+type derivedField struct {
+	inputs []derivedInput
+
+	// compute returns the value of the field given the values of inputs, in the same order
+	compute func(v []float64) float64
+
+	// valid tells whether the values of inputs can be computed from, nil if all values can
+	valid func(v []float64) bool
+
+	// condition is the Flux equivalent of valid, a predicate on r, empty if all values can be computed from
+	condition string
+
+	// expression is the Flux equivalent of compute: statements using the variables of inputs
+	// which assign the value of the field to value
+	expression string
+}
+
+// fromClimate adapts f of temperature and humidity to compute.
+func fromClimate(f func(t, rh float64) float64) func(v []float64) float64 {
+	return func(v []float64) float64 {
+		return f(v[0], v[1])
+	}
+}
+
+// humidityMeasured leaves out zero humidity reported by failing sensors, which the Magnus formula cannot handle.
+func humidityMeasured(v []float64) bool {
+	return v[1] > 0
+}
+
+// accelerationInvalid is the acceleration RuuviTag data format 5 reports along an axis when it is not available.
+const accelerationInvalid = -32.768
+
+// accelerationMeasured leaves out acceleration unavailable along any axis, which would distort the magnitude.
+func accelerationMeasured(v []float64) bool {
+	for _, a := range v {
+		if a <= accelerationInvalid {
+			return false
+		}
+	}
+	return true
+}
+
+// Magnus formula coefficients over water, valid for -45 °C to 60 °C
+const (
+	magnusA = 17.62
+	magnusB = 243.12
+)
+
+// magnusExpression computes gamma of the Magnus formula in Flux.
+const magnusExpression = `gamma = math.log(x: rh / 100.0) + 17.62 * t / (243.12 + t)`
+
+var derivedFields = map[string]derivedField{
+	"dewpoint": {
+		inputs:    climateInputs,
+		compute:   fromClimate(dewPoint),
+		valid:     humidityMeasured,
+		condition: `r.humidity > 0.0`,
+		expression: magnusExpression + `
+value = 243.12 * gamma / (17.62 - gamma)`,
+	},
+	"absolutehumidity": {
+		inputs:     climateInputs,
+		compute:    fromClimate(absoluteHumidity),
+		valid:      humidityMeasured,
+		condition:  `r.humidity > 0.0`,
+		expression: `value = 6.112 * math.exp(x: 17.67 * t / (t + 243.5)) * rh * 2.1674 / (273.15 + t)`,
+	},
+	"humidex": {
+		inputs:    climateInputs,
+		compute:   fromClimate(humidex),
+		valid:     humidityMeasured,
+		condition: `r.humidity > 0.0`,
+		expression: magnusExpression + `
+td = 243.12 * gamma / (17.62 - gamma)
+value = t + 0.5555 * (6.11 * math.exp(x: 5417.7530 * (1.0 / 273.16 - 1.0 / (273.15 + td))) - 10.0)`,
+	},
+	"acceleration": {
+		inputs:     accelerationInputs,
+		compute:    totalAcceleration,
+		valid:      accelerationMeasured,
+		condition:  `r.accelerationX > -32.768 and r.accelerationY > -32.768 and r.accelerationZ > -32.768`,
+		expression: `value = math.sqrt(x: x * x + y * y + z * z)`,
+	},
+}
+
+// DerivedFields returns the names of fields computed from other fields.
+func DerivedFields() []string {
+	names := make([]string, 0, len(derivedFields))
+	for name := range derivedFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// withDerivedFields adds the derived and virtual fields whose inputs are all included in fields.
+func withDerivedFields(fields []string) []string {
+	has := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		has[field] = true
+	}
+	result := append([]string(nil), fields...)
+	for _, name := range DerivedFields() {
+		if derivedFields[name].computableFrom(has) {
+			result = append(result, name)
+		}
+	}
+	if has["temperature"] && has["humidity"] {
+		result = append(result, mouldIndexField)
+	}
+	if has[movementCounterField] && has[sequenceNumberField] {
+		result = append(result, movementsField)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (d derivedField) computableFrom(fields map[string]bool) bool {
+	for _, input := range d.inputs {
+		if !fields[input.field] {
+			return false
+		}
+	}
+	return true
+}
+
+func (d derivedField) inputFields() []string {
+	fields := make([]string, len(d.inputs))
+	for i, input := range d.inputs {
+		fields[i] = input.field
+	}
+	return fields
+}
+
+// dewPoint returns the dew point in Celsius using the Magnus formula.
+func dewPoint(t, rh float64) float64 {
+	gamma := math.Log(rh/100) + magnusA*t/(magnusB+t)
+	return magnusB * gamma / (magnusA - gamma)
+}
+
+// absoluteHumidity returns the mass of water vapour in grams per cubic meter of air.
+func absoluteHumidity(t, rh float64) float64 {
+	return 6.112 * math.Exp(17.67*t/(t+243.5)) * rh * 2.1674 / (273.15 + t)
+}
+
+// humidex returns the Canadian humidex, the temperature perceived in humid heat.
+func humidex(t, rh float64) float64 {
+	td := dewPoint(t, rh)
+	return t + 0.5555*(6.11*math.Exp(5417.7530*(1/273.16-1/(273.15+td)))-10)
+}
+
+// totalAcceleration returns the magnitude of the acceleration vector of the x, y and z components.
+// At rest it is about 1 g, the gravity of Earth.
+func totalAcceleration(v []float64) float64 {
+	return math.Sqrt(v[0]*v[0] + v[1]*v[1] + v[2]*v[2])
+}
+
+// filter narrows query, already limited to sensorIDs, to the input records of the same time,
+// and replaces them with the value of the derived field.
+// The result looks like records of a field called name.
+func (d derivedField) filter(query *flux.Query, name string, sensorIDs []string) *flux.Query {
+	return d.fromInputs(d.filterInputs(query), name, sensorIDs)
+}
+
+// filterInputs narrows query to the fields d is computed from.
+func (d derivedField) filterInputs(query *flux.Query) *flux.Query {
+	return query.FilterIn("_field", d.inputFields())
+}
+
+// fromInputs replaces input records of the same time from sensorIDs with the value of the derived field,
+// computed from the calibrated values of the inputs.
+func (d derivedField) fromInputs(query *flux.Query, name string, sensorIDs []string) *flux.Query {
+	var (
+		exists    []string
+		variables string
+		values    []interface{}
+	)
+	for _, input := range d.inputs {
+		exists = append(exists, "exists r."+input.field)
+		expression, expressionValues := calibratedInput(input.field, sensorIDs)
+		variables += input.variable + " = " + expression + "\n"
+		values = append(values, expressionValues...)
+	}
+	if d.condition != "" {
+		exists = append(exists, d.condition)
+	}
+	columns := make([]interface{}, len(d.inputs))
+	for i, input := range d.inputs {
+		columns[i] = input.field
+	}
+	return query.Import("math").
+		Add(`|> pivot(rowKey: ["_time"], columnKey: ["_field"], valueColumn: "_value")`).
+		Add(`|> filter(fn: (r) => `+strings.Join(exists, " and ")+`)`).
+		Add(`|> map(fn: (r) => {
+`+variables+d.expression+`
+return {r with _field: ?, _value: value}
+})`, append(values, name)...).
+		Add(`|> drop(columns: [`+strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")+`])`, columns...)
+}
+
+// derive computes field name of sensor id from the series of its calibrated inputs, in the order of inputs,
+// joining measurements of equal time. All series must be sorted by time.
+func (d derivedField) derive(name, id string, inputs [][]Measurement) []Measurement {
+	var derived []Measurement
+	if len(inputs) != len(d.inputs) {
+		return nil
+	}
+	next := make([]int, len(inputs))
+	values := make([]float64, len(inputs))
+	for {
+		// advance every series to the latest time among their current measurements
+		var latest time.Time
+		for i, series := range inputs {
+			if next[i] >= len(series) {
+				return derived
+			}
+			if t := series[next[i]].Time(); t.After(latest) {
+				latest = t
+			}
+		}
+		aligned := true
+		for i, series := range inputs {
+			if series[next[i]].Time().Before(latest) {
+				next[i]++
+				aligned = false
+			}
+		}
+		if !aligned {
+			continue
+		}
+
+		ok := true
+		for i, series := range inputs {
+			v, valueOK := floatValue(series[next[i]])
+			values[i] = v
+			ok = ok && valueOK
+			next[i]++
+		}
+		if !ok || (d.valid != nil && !d.valid(values)) {
+			continue
+		}
+		m, err := NewMeasurement(name, id, d.compute(values), latest)
+		if err != nil {
+			continue
+		}
+		derived = append(derived, m)
+	}
+}
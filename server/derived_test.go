@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LassiHeikkila/mokki-cloud/server/flux"
+)
+
+func TestAccelerationLeavesOutUnavailableAxes(t *testing.T) {
+	start := time.Date(2022, 10, 30, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	addSeries(t, store, "accelerationX", "sensor", []float64{0, accelerationInvalid, 0, 0.6}, start, time.Minute)
+	addSeries(t, store, "accelerationY", "sensor", []float64{0, 0, 0, 0}, start, time.Minute)
+	addSeries(t, store, "accelerationZ", "sensor", []float64{1, 1, accelerationInvalid, 0.8}, start, time.Minute)
+	query := RangeQuery{Field: "acceleration", SensorID: "sensor", Start: start, Stop: start.Add(time.Hour), Raw: true}
+
+	measurements, _, err := store.Range(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(measurements) != 2 {
+		t.Fatalf("got %d measurements, want 2", len(measurements))
+	}
+	for _, m := range measurements {
+		if v, _ := floatValue(m); v < 0.999 || v > 1.001 {
+			t.Errorf("acceleration at %s = %v, want 1", m.Time(), v)
+		}
+	}
+}
+
+func TestDerivedFormulasMatchReferenceValues(t *testing.T) {
+	// rhFromDewPoint inverts the Magnus formula of dewPoint
+	rhFromDewPoint := func(t, td float64) float64 {
+		return 100 * math.Exp(magnusA*td/(magnusB+td)-magnusA*t/(magnusB+t))
+	}
+	tests := []struct {
+		name      string
+		value     float64
+		want      float64
+		tolerance float64
+	}{
+		{"dew point 20 °C 50 %", dewPoint(20, 50), 9.3, 0.1},
+		{"dew point 25 °C 60 %", dewPoint(25, 60), 16.7, 0.1},
+		{"dew point 30 °C 80 %", dewPoint(30, 80), 26.2, 0.1},
+		{"dew point 10 °C 100 %", dewPoint(10, 100), 10, 1e-9},
+		{"absolute humidity 0 °C 100 %", absoluteHumidity(0, 100), 4.85, 0.05},
+		{"absolute humidity 20 °C 100 %", absoluteHumidity(20, 100), 17.3, 0.05},
+		{"absolute humidity 30 °C 50 %", absoluteHumidity(30, 50), 15.2, 0.05},
+		// humidex table of Environment Canada by temperature and dew point
+		{"humidex 30 °C dew point 15 °C", humidex(30, rhFromDewPoint(30, 15)), 34, 0.5},
+		{"humidex 30 °C dew point 25 °C", humidex(30, rhFromDewPoint(30, 25)), 42, 0.5},
+		{"humidex 35 °C dew point 25 °C", humidex(35, rhFromDewPoint(35, 25)), 47, 0.5},
+		{"acceleration at rest", totalAcceleration([]float64{0.6, 0, 0.8}), 1, 1e-9},
+	}
+	for _, tt := range tests {
+		if math.Abs(tt.value-tt.want) > tt.tolerance {
+			t.Errorf("%s = %v, want %v", tt.name, tt.value, tt.want)
+		}
+	}
+}
+
+// evaluateExpression evaluates the statements of the Flux expression of a derived field
+// given the values of its variables, and returns the value assigned to value.
+// It supports the arithmetic and math functions the expressions use.
+func evaluateExpression(t *testing.T, expression string, variables map[string]float64) float64 {
+	t.Helper()
+	var evaluate func(e ast.Expr) float64
+	evaluate = func(e ast.Expr) float64 {
+		switch e := e.(type) {
+		case *ast.ParenExpr:
+			return evaluate(e.X)
+		case *ast.BasicLit:
+			v, err := strconv.ParseFloat(e.Value, 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return v
+		case *ast.Ident:
+			v, ok := variables[e.Name]
+			if !ok {
+				t.Fatalf("undefined variable %s", e.Name)
+			}
+			return v
+		case *ast.UnaryExpr:
+			if e.Op == token.SUB {
+				return -evaluate(e.X)
+			}
+		case *ast.BinaryExpr:
+			x, y := evaluate(e.X), evaluate(e.Y)
+			switch e.Op {
+			case token.ADD:
+				return x + y
+			case token.SUB:
+				return x - y
+			case token.MUL:
+				return x * y
+			case token.QUO:
+				return x / y
+			}
+		case *ast.CallExpr:
+			functions := map[string]func(float64) float64{"log": math.Log, "exp": math.Exp, "sqrt": math.Sqrt}
+			if selector, ok := e.Fun.(*ast.SelectorExpr); ok && len(e.Args) == 1 {
+				if f, ok := functions[selector.Sel.Name]; ok {
+					return f(evaluate(e.Args[0]))
+				}
+			}
+		}
+		t.Fatalf("unsupported expression %T", e)
+		return 0
+	}
+
+	for _, statement := range strings.Split(expression, "\n") {
+		name, value, ok := strings.Cut(statement, " = ")
+		if !ok {
+			t.Fatalf("not an assignment: %s", statement)
+		}
+		// named arguments of Flux functions are positional in Go
+		e, err := parser.ParseExpr(strings.ReplaceAll(value, "x: ", ""))
+		if err != nil {
+			t.Fatalf("parsing %s: %v", value, err)
+		}
+		variables[name] = evaluate(e)
+	}
+	return variables["value"]
+}
+
+func TestDerivedExpressionsMatchCompute(t *testing.T) {
+	inputs := map[string][][]float64{
+		"dewpoint":         {{20, 50}, {-10, 90}, {30, 80}, {5, 3}},
+		"absolutehumidity": {{20, 50}, {-10, 90}, {30, 80}, {5, 3}},
+		"humidex":          {{20, 50}, {-10, 90}, {30, 80}, {35, 60}},
+		"acceleration":     {{0, 0, 1}, {0.6, -0.8, 0}, {-1.5, 2, 0.25}},
+	}
+	for _, name := range DerivedFields() {
+		d := derivedFields[name]
+		if len(inputs[name]) == 0 {
+			t.Errorf("no test inputs for %s", name)
+		}
+		for _, v := range inputs[name] {
+			variables := make(map[string]float64, len(d.inputs))
+			for i, input := range d.inputs {
+				variables[input.variable] = v[i]
+			}
+			got, want := evaluateExpression(t, d.expression, variables), d.compute(v)
+			if math.Abs(got-want) > 1e-9*math.Max(1, math.Abs(want)) {
+				t.Errorf("%s of %v is %v in Flux, want %v", name, v, got, want)
+			}
+		}
+
+		text := d.filter(flux.From("bucket"), name, []string{"sensor"}).String()
+		if !strings.Contains(text, d.expression) {
+			t.Errorf("query of %s lacks its expression:\n%s", name, text)
+		}
+		if d.condition != "" && !strings.Contains(text, d.condition) {
+			t.Errorf("query of %s lacks its condition:\n%s", name, text)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ValueKind is the kind of number values of a field are returned as.
+type ValueKind string
+
+const (
+	KindFloat ValueKind = "float"
+	KindInt   ValueKind = "int"
+)
+
+// FieldType declares a field which can be served: the name of the field in the database,
+// the JSON key its values are returned with, the kind of number they are returned as,
+// their unit, the plausible values of the field, if known, and the aggregate used when none is requested.
+// Measurements outside Bounds are rejected before aggregation unless raw data is requested.
+type FieldType struct {
+	Name      string    `json:"name"`
+	Key       string    `json:"key,omitempty"`
+	Kind      ValueKind `json:"kind,omitempty"`
+	Unit      string    `json:"unit,omitempty"`
+	Bounds    *Bounds   `json:"bounds,omitempty"`
+	Aggregate string    `json:"aggregate,omitempty"`
+}
+
+// fieldTypes holds the fields which can be served, keyed by name.
+// Fields can be added with RegisterFieldType, e.g. from a config file.
+var fieldTypes = map[string]FieldType{}
+
+func init() {
+	for _, ft := range []FieldType{
+		// RuuviTags report e.g. -163.835 °C when the sensor fails
+		{Name: "temperature", Unit: "°C", Bounds: &Bounds{Min: -60, Max: 100}},
+		{Name: "humidity", Unit: "%", Bounds: &Bounds{Min: 0.1, Max: 100}},
+		{Name: "pressure", Kind: KindInt, Unit: "Pa", Bounds: &Bounds{Min: 50000, Max: 115000}},
+		{Name: "batteryvoltage", Key: "voltage", Unit: "V", Bounds: &Bounds{Min: 1.6, Max: 3.7}},
+		{Name: "co2", Kind: KindInt, Unit: "ppm", Bounds: &Bounds{Min: 1, Max: 40000}},
+		{Name: "pm2p5", Unit: "µg/m³", Bounds: &Bounds{Min: 0, Max: 1000}},
+
+		// the rest of RuuviTag data format 5
+		{Name: "accelerationX", Unit: "g", Bounds: &Bounds{Min: -32, Max: 32}},
+		{Name: "accelerationY", Unit: "g", Bounds: &Bounds{Min: -32, Max: 32}},
+		{Name: "accelerationZ", Unit: "g", Bounds: &Bounds{Min: -32, Max: 32}},
+		{Name: movementCounterField, Kind: KindInt, Bounds: &Bounds{Min: 0, Max: movementCounterModulus - 1}},
+		{Name: "measurementSequenceNumber", Kind: KindInt, Bounds: &Bounds{Min: 0, Max: 65534}},
+		{Name: "txPower", Kind: KindInt, Unit: "dBm", Bounds: &Bounds{Min: -40, Max: 20}},
+		{Name: "rssi", Kind: KindInt, Unit: "dBm", Bounds: &Bounds{Min: -127, Max: 0}},
+
+		// derived from temperature and humidity
+		{Name: "dewpoint", Unit: "°C", Bounds: &Bounds{Min: -80, Max: 60}},
+		{Name: "absolutehumidity", Unit: "g/m³", Bounds: &Bounds{Min: 0, Max: 200}},
+		{Name: "humidex", Unit: "°C", Bounds: &Bounds{Min: -60, Max: 80}},
+		// derived from acceleration along each axis
+		{Name: "acceleration", Unit: "g", Bounds: &Bounds{Min: 0, Max: 56}},
+
+		// VTT mould growth index from 0 to 6, no unit
+		{Name: mouldIndexField},
+		// movements detected between reports, see filterMovements
+		{Name: movementsField, Aggregate: "sum"},
+	} {
+		if err := RegisterFieldType(ft); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// RegisterFieldType adds ft, or replaces the field type with the same name.
+// Key defaults to Name, Kind to KindFloat and Aggregate to mean.
+func RegisterFieldType(ft FieldType) error {
+	if ft.Name == "" {
+		return errors.New("field type without name")
+	}
+	if ft.Key == "" {
+		ft.Key = ft.Name
+	}
+	if ft.Key == "sensorID" || ft.Key == "time" || ft.Key == "unit" {
+		return fmt.Errorf("%s: key %s is reserved", ft.Name, ft.Key)
+	}
+	switch ft.Kind {
+	case "":
+		ft.Kind = KindFloat
+	case KindFloat, KindInt:
+	default:
+		return fmt.Errorf("%s: unknown kind: %s", ft.Name, ft.Kind)
+	}
+	if ft.Bounds != nil && ft.Bounds.Min > ft.Bounds.Max {
+		return fmt.Errorf("%s: bounds min is greater than max", ft.Name)
+	}
+	if _, err := ParseAggregate(ft.Aggregate); err != nil {
+		return fmt.Errorf("%s: %w", ft.Name, err)
+	}
+	fieldTypes[ft.Name] = ft
+	return nil
+}
+
+// SetFieldBounds replaces the plausible values of a registered field.
+func SetFieldBounds(field string, bounds Bounds) error {
+	ft, ok := fieldTypes[field]
+	if !ok {
+		return errors.New("unknown field: " + field)
+	}
+	ft.Bounds = &bounds
+	return RegisterFieldType(ft)
+}
+
+// FieldTypes returns the registered field types ordered by name.
+func FieldTypes() []FieldType {
+	types := make([]FieldType, 0, len(fieldTypes))
+	for _, ft := range fieldTypes {
+		types = append(types, ft)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i].Name < types[j].Name })
+	return types
+}
+
+// fieldAggregate parses the aggregate s requested for field, defaulting to the aggregate of its FieldType.
+func fieldAggregate(field, s string) (Aggregate, error) {
+	if s == "" {
+		s = fieldTypes[field].Aggregate
+	}
+	return ParseAggregate(s)
+}
+
+// fieldBounds returns the plausible values of field, if it has any.
+func fieldBounds(field string) (Bounds, bool) {
+	ft, ok := fieldTypes[field]
+	if !ok || ft.Bounds == nil {
+		return Bounds{}, false
+	}
+	return *ft.Bounds, true
+}
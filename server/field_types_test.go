@@ -0,0 +1,104 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+// restoreFieldTypes restores the registered field types after the test.
+func restoreFieldTypes(t *testing.T) {
+	t.Helper()
+	saved := make(map[string]FieldType, len(fieldTypes))
+	for name, ft := range fieldTypes {
+		saved[name] = ft
+	}
+	t.Cleanup(func() {
+		fieldTypes = saved
+	})
+}
+
+func TestRegisterFieldType(t *testing.T) {
+	restoreFieldTypes(t)
+	if err := RegisterFieldType(FieldType{Name: "radon", Unit: "Bq/m³"}); err != nil {
+		t.Fatal(err)
+	}
+	want := FieldType{Name: "radon", Key: "radon", Kind: KindFloat, Unit: "Bq/m³"}
+	if got := fieldTypes["radon"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("registered %+v, want %+v", got, want)
+	}
+
+	// replacing a type replaces all of it
+	replacement := FieldType{Name: "radon", Key: "rn", Kind: KindInt, Bounds: &Bounds{Min: 0, Max: 10000}, Aggregate: "p95"}
+	if err := RegisterFieldType(replacement); err != nil {
+		t.Fatal(err)
+	}
+	if got := fieldTypes["radon"]; !reflect.DeepEqual(got, replacement) {
+		t.Errorf("replaced with %+v, want %+v", got, replacement)
+	}
+	if bounds, ok := fieldBounds("radon"); !ok || bounds != *replacement.Bounds {
+		t.Errorf("bounds of radon are %+v, want %+v", bounds, *replacement.Bounds)
+	}
+	if aggregate, err := fieldAggregate("radon", ""); err != nil || aggregate.String() != "p95" {
+		t.Errorf("default aggregate of radon is %s (%v), want p95", aggregate, err)
+	}
+}
+
+func TestRegisterFieldTypeRejectsInvalid(t *testing.T) {
+	restoreFieldTypes(t)
+	temperature := fieldTypes["temperature"]
+
+	for _, ft := range []FieldType{
+		{},
+		{Name: "sensorID"},
+		{Name: "time"},
+		{Name: "unit"},
+		{Name: "temperature", Key: "time"},
+		{Name: "temperature", Kind: "string"},
+		{Name: "temperature", Kind: "Float"},
+		{Name: "temperature", Bounds: &Bounds{Min: 100, Max: -60}},
+		{Name: "temperature", Aggregate: "average"},
+		{Name: "temperature", Aggregate: "p101"},
+	} {
+		if err := RegisterFieldType(ft); err == nil {
+			t.Errorf("RegisterFieldType(%+v) was accepted", ft)
+		}
+	}
+	if got := fieldTypes["temperature"]; !reflect.DeepEqual(got, temperature) {
+		t.Errorf("rejected field types replaced temperature with %+v", got)
+	}
+	if _, ok := fieldTypes[""]; ok {
+		t.Error("registered a field type without name")
+	}
+}
+
+func TestSetFieldBounds(t *testing.T) {
+	restoreFieldTypes(t)
+	temperature := fieldTypes["temperature"]
+
+	if err := SetFieldBounds("temperature", Bounds{Min: -30, Max: 50}); err != nil {
+		t.Fatal(err)
+	}
+	if bounds, _ := fieldBounds("temperature"); bounds != (Bounds{Min: -30, Max: 50}) {
+		t.Errorf("bounds of temperature are %+v, want [-30, 50]", bounds)
+	}
+	got := fieldTypes["temperature"]
+	if got.Unit != temperature.Unit || got.Kind != temperature.Kind || got.Key != temperature.Key {
+		t.Errorf("setting bounds changed temperature to %+v", got)
+	}
+	if *temperature.Bounds != (Bounds{Min: -60, Max: 100}) {
+		t.Errorf("setting bounds modified the bounds of the previous type to %+v", *temperature.Bounds)
+	}
+
+	if err := SetFieldBounds("temperature", Bounds{Min: 50, Max: -30}); err == nil {
+		t.Error("inverted bounds were accepted")
+	}
+	if bounds, _ := fieldBounds("temperature"); bounds != (Bounds{Min: -30, Max: 50}) {
+		t.Errorf("inverted bounds changed the bounds of temperature to %+v", bounds)
+	}
+	if err := SetFieldBounds("radon", Bounds{Min: 0, Max: 10000}); err == nil {
+		t.Error("bounds of an unknown field were accepted")
+	}
+	if _, ok := fieldTypes["radon"]; ok {
+		t.Error("setting bounds registered an unknown field")
+	}
+}
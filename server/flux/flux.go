@@ -0,0 +1,389 @@
+// Package flux builds Flux queries without splicing untrusted input into the query text.
+//
+// Queries are assembled from templates where each ? is a placeholder for a value.
+// Values are either rendered as escaped Flux literals, or passed to InfluxDB as
+// query parameters and referenced as params.<name> in the query text.
+package flux
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var identifierRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Identifier returns an error if name is not a valid Flux identifier.
+func Identifier(name string) error {
+	if !identifierRegexp.MatchString(name) {
+		return fmt.Errorf("invalid identifier: %q", name)
+	}
+	return nil
+}
+
+// String returns s as a quoted Flux string literal.
+func String(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '$':
+			// ${ would start string interpolation
+			b.WriteString(`\$`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// Duration returns d as a Flux duration literal using the largest unit which represents it exactly.
+func Duration(d time.Duration) string {
+	if d == 0 {
+		return "0s"
+	}
+	sign := ""
+	if d < 0 {
+		sign = "-"
+		d = -d
+	}
+	units := []struct {
+		suffix string
+		length time.Duration
+	}{
+		{"d", 24 * time.Hour},
+		{"h", time.Hour},
+		{"m", time.Minute},
+		{"s", time.Second},
+		{"ms", time.Millisecond},
+		{"us", time.Microsecond},
+	}
+	for _, u := range units {
+		if d%u.length == 0 {
+			return sign + strconv.FormatInt(int64(d/u.length), 10) + u.suffix
+		}
+	}
+	return sign + strconv.FormatInt(int64(d), 10) + "ns"
+}
+
+// Time returns t as a Flux time literal.
+func Time(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// Literal returns v as a Flux literal.
+// Supported types are string, bool, integers, floats, time.Time and time.Duration.
+func Literal(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return String(v), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return "", fmt.Errorf("cannot represent %v in Flux", v)
+		}
+		s := strconv.FormatFloat(v, 'f', -1, 64)
+		if !strings.Contains(s, ".") {
+			s += ".0"
+		}
+		return s, nil
+	case time.Time:
+		return Time(v), nil
+	case time.Duration:
+		return Duration(v), nil
+	}
+	return "", fmt.Errorf("unsupported literal type %T", v)
+}
+
+// Query is a Flux query under construction.
+// The first error encountered while building is kept and returned by Err.
+type Query struct {
+	imports []string
+	options []part
+	parts   []part
+	err     error
+}
+
+// part is a template and the values substituted into its placeholders.
+type part struct {
+	template string
+	values   []interface{}
+
+	// option is the name of the option set by the part, if any
+	option string
+}
+
+func New() *Query {
+	return &Query{}
+}
+
+// Import adds an import statement for pkg to the query.
+func (q *Query) Import(pkg string) *Query {
+	for _, existing := range q.imports {
+		if existing == pkg {
+			return q
+		}
+	}
+	q.imports = append(q.imports, pkg)
+	return q
+}
+
+// Add appends template to the query, substituting each ? with the next value.
+// Template must be trusted text, never input from a request.
+func (q *Query) Add(template string, values ...interface{}) *Query {
+	p, err := newPart(template, values)
+	if err != nil {
+		return q.fail(err)
+	}
+	q.parts = append(q.parts, p)
+	return q
+}
+
+// Option adds an option statement, which is placed after the imports
+// and before everything added with Add. Template is handled like in Add.
+// Flux allows setting an option only once, so an option set again replaces
+// the earlier statement.
+func (q *Query) Option(template string, values ...interface{}) *Query {
+	name := strings.TrimSpace(strings.SplitN(template, "=", 2)[0])
+	if err := Identifier(name); err != nil {
+		return q.fail(err)
+	}
+	p, err := newPart("option "+template, values)
+	if err != nil {
+		return q.fail(err)
+	}
+	p.option = name
+	for i, existing := range q.options {
+		if existing.option == name {
+			q.options[i] = p
+			return q
+		}
+	}
+	q.options = append(q.options, p)
+	return q
+}
+
+func newPart(template string, values []interface{}) (part, error) {
+	if n := strings.Count(template, "?"); n != len(values) {
+		return part{}, fmt.Errorf("template has %d placeholders but %d values were given", n, len(values))
+	}
+	for _, v := range values {
+		if _, err := Literal(v); err != nil {
+			return part{}, err
+		}
+	}
+	return part{template: template, values: values}, nil
+}
+
+// Err returns the first error encountered while building the query.
+func (q *Query) Err() error {
+	return q.err
+}
+
+// String renders the query with all values inlined as escaped literals.
+func (q *Query) String() string {
+	text, _ := q.render(false)
+	return text
+}
+
+// Parameterized renders the query with values referenced as params.pN,
+// and returns the parameters to send along with the query.
+// Durations cannot be passed as parameters, and parameters are sent as JSON,
+// which cannot tell floats from integers, so numbers are always inlined too,
+// keeping their Flux type. Neither numbers nor booleans can carry anything harmful.
+// Times are passed as RFC3339 strings and converted back with time(v: ...).
+func (q *Query) Parameterized() (string, map[string]interface{}) {
+	return q.render(true)
+}
+
+func (q *Query) render(useParams bool) (string, map[string]interface{}) {
+	var lines []string
+	for _, pkg := range q.imports {
+		lines = append(lines, "import "+String(pkg))
+	}
+
+	params := make(map[string]interface{})
+	for _, p := range append(append([]part(nil), q.options...), q.parts...) {
+		var b strings.Builder
+		template := p.template
+		for _, v := range p.values {
+			idx := strings.IndexByte(template, '?')
+			b.WriteString(template[:idx])
+			template = template[idx+1:]
+
+			if useParams && parameterizable(v) {
+				name := "p" + strconv.Itoa(len(params))
+				if t, ok := v.(time.Time); ok {
+					// parameters are sent as JSON, which has no time type
+					params[name] = Time(t)
+					b.WriteString("time(v: params." + name + ")")
+					continue
+				}
+				params[name] = v
+				b.WriteString("params." + name)
+				continue
+			}
+			literal, _ := Literal(v)
+			b.WriteString(literal)
+		}
+		b.WriteString(template)
+		lines = append(lines, b.String())
+	}
+	return strings.Join(lines, "\n"), params
+}
+
+func parameterizable(v interface{}) bool {
+	switch v.(type) {
+	case time.Duration, bool, int, int64, float64:
+		return false
+	}
+	return true
+}
+
+// From starts a query reading from bucket.
+func From(bucket string) *Query {
+	return New().Add(`from(bucket: ?)`, bucket)
+}
+
+// Range limits the query to records between start (inclusive) and stop (exclusive).
+func (q *Query) Range(start, stop time.Time) *Query {
+	return q.Add(`|> range(start: ?, stop: ?)`, start, stop)
+}
+
+// RangeSince limits the query to records from the past d.
+func (q *Query) RangeSince(d time.Duration) *Query {
+	return q.Add(`|> range(start: ?)`, -d)
+}
+
+// FilterEquals keeps records whose column equals value.
+func (q *Query) FilterEquals(column, value string) *Query {
+	if err := Identifier(column); err != nil {
+		return q.fail(err)
+	}
+	return q.Add(`|> filter(fn: (r) => r[`+String(column)+`] == ?)`, value)
+}
+
+// FilterIn keeps records whose column equals any of values.
+func (q *Query) FilterIn(column string, values []string) *Query {
+	if err := Identifier(column); err != nil {
+		return q.fail(err)
+	}
+	if len(values) == 0 {
+		return q.fail(errors.New("no values to filter by"))
+	}
+	conditions := make([]string, len(values))
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		conditions[i] = `r[` + String(column) + `] == ?`
+		args[i] = v
+	}
+	return q.Add(`|> filter(fn: (r) => `+strings.Join(conditions, " or ")+`)`, args...)
+}
+
+// Window describes the aggregation windows of aggregateWindow.
+type Window struct {
+	// Every is the length of each window
+	Every time.Duration
+
+	// Months is the length of each window in calendar months. If non-zero, Every is ignored.
+	Months int
+
+	// Offset shifts the window boundaries
+	Offset time.Duration
+}
+
+// arguments returns the every and offset arguments of aggregateWindow for w.
+// Calendar months cannot be represented as time.Duration, so the month
+// literal is part of the returned template.
+func (w Window) arguments() (string, []interface{}, error) {
+	var (
+		args   string
+		values []interface{}
+	)
+	switch {
+	case w.Months > 0:
+		args = `every: ` + strconv.Itoa(w.Months) + `mo`
+	case w.Every > 0:
+		args = `every: ?`
+		values = append(values, w.Every)
+	default:
+		return "", nil, errors.New("aggregation window must be positive")
+	}
+	if w.Offset != 0 {
+		args += `, offset: ?`
+		values = append(values, w.Offset)
+	}
+	return args, values, nil
+}
+
+// AggregateWindow aggregates records into windows using function fn.
+func (q *Query) AggregateWindow(window Window, fn string, createEmpty bool) *Query {
+	if err := Identifier(fn); err != nil {
+		return q.fail(err)
+	}
+	args, values, err := window.arguments()
+	if err != nil {
+		return q.fail(err)
+	}
+	return q.Add(`|> aggregateWindow(`+args+`, fn: `+fn+`, createEmpty: ?)`, append(values, createEmpty)...)
+}
+
+// AggregateWindowQuantile aggregates records into windows using quantile q.
+func (q *Query) AggregateWindowQuantile(window Window, quantile float64, createEmpty bool) *Query {
+	if quantile < 0 || quantile > 1 {
+		return q.fail(fmt.Errorf("quantile %v out of range [0, 1]", quantile))
+	}
+	args, values, err := window.arguments()
+	if err != nil {
+		return q.fail(err)
+	}
+	return q.Add(
+		`|> aggregateWindow(`+args+`, fn: (column, tables=<-) => tables |> quantile(q: ?, column: column), createEmpty: ?)`,
+		append(values, quantile, createEmpty)...,
+	)
+}
+
+// Location sets the time zone used for window boundaries and calendar arithmetic,
+// so that e.g. daily windows start at local midnight also across DST changes.
+func (q *Query) Location(name string) *Query {
+	return q.Import("timezone").Option(`location = timezone.location(name: ?)`, name)
+}
+
+// Last keeps the last record of each table.
+func (q *Query) Last() *Query {
+	return q.Add(`|> last()`)
+}
+
+// Yield names the result of the query.
+func (q *Query) Yield(name string) *Query {
+	if err := Identifier(name); err != nil {
+		return q.fail(err)
+	}
+	return q.Add(`|> yield(name: ` + String(name) + `)`)
+}
+
+func (q *Query) fail(err error) *Query {
+	if q.err == nil {
+		q.err = err
+	}
+	return q
+}
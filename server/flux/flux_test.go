@@ -0,0 +1,343 @@
+package flux
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// injectionPayloads try to break out of a string literal in various ways.
+var injectionPayloads = []string{
+	`plain`,
+	`"`,
+	`\`,
+	`\"`,
+	`abc" or true or r["x"] == "`,
+	`abc\" or true or "`,
+	`${r._value}`,
+	`$${r._value}`,
+	"line\nbreak",
+	"cr\rlf\n",
+	"tab\there",
+	`") |> drop(columns: ["_value"]) |> yield(name: "x`,
+	`\") |> yield() //`,
+	"\") \n|> to(bucket: \"other\")\n//",
+}
+
+// scanLiterals splits Flux text into the code outside string literals and the
+// unescaped contents of the literals, failing on anything a literal produced by
+// String must not contain.
+func scanLiterals(t *testing.T, text string) (string, []string) {
+	t.Helper()
+	var (
+		code     strings.Builder
+		literals []string
+	)
+	for i := 0; i < len(text); i++ {
+		if text[i] != '"' {
+			code.WriteByte(text[i])
+			continue
+		}
+		code.WriteString(`""`)
+		var literal strings.Builder
+		for i++; ; i++ {
+			if i >= len(text) {
+				t.Fatalf("unterminated string literal in %q", text)
+			}
+			c := text[i]
+			if c == '"' {
+				break
+			}
+			if c == '\n' || c == '\r' {
+				t.Fatalf("raw line break in string literal in %q", text)
+			}
+			if c == '$' && i+1 < len(text) && text[i+1] == '{' {
+				t.Fatalf("string interpolation in string literal in %q", text)
+			}
+			if c != '\\' {
+				literal.WriteByte(c)
+				continue
+			}
+			i++
+			if i >= len(text) {
+				t.Fatalf("dangling escape in %q", text)
+			}
+			switch text[i] {
+			case '"', '\\', '$':
+				literal.WriteByte(text[i])
+			case 'n':
+				literal.WriteByte('\n')
+			case 'r':
+				literal.WriteByte('\r')
+			case 't':
+				literal.WriteByte('\t')
+			default:
+				t.Fatalf("unknown escape \\%c in %q", text[i], text)
+			}
+		}
+		literals = append(literals, literal.String())
+	}
+	return code.String(), literals
+}
+
+func TestString(t *testing.T) {
+	for _, payload := range injectionPayloads {
+		rendered := String(payload)
+		code, literals := scanLiterals(t, rendered)
+		if code != `""` || len(literals) != 1 {
+			t.Errorf("String(%q) = %s, breaks out of the literal", payload, rendered)
+			continue
+		}
+		if literals[0] != payload {
+			t.Errorf("String(%q) = %s, which reads back as %q", payload, rendered, literals[0])
+		}
+	}
+}
+
+func TestIdentifier(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"_field", true},
+		{"sensormac", true},
+		{"mean", true},
+		{"A1_b2", true},
+		{"", false},
+		{"1a", false},
+		{"a-b", false},
+		{"a b", false},
+		{`a"b`, false},
+		{"a\nb", false},
+		{"mean) |> yield(name: \"x\"", false},
+		{"${x}", false},
+	}
+	for _, tt := range tests {
+		err := Identifier(tt.name)
+		if tt.valid && err != nil {
+			t.Errorf("Identifier(%q) = %v, want nil", tt.name, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("Identifier(%q) = nil, want error", tt.name)
+		}
+	}
+}
+
+func TestLiteral(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+		err   bool
+	}{
+		{"abc", `"abc"`, false},
+		{`a"b`, `"a\"b"`, false},
+		{`${x}`, `"\${x}"`, false},
+		{true, "true", false},
+		{42, "42", false},
+		{int64(-7), "-7", false},
+		{1.5, "1.5", false},
+		{2.0, "2.0", false},
+		{-0.25, "-0.25", false},
+		{time.Date(2022, 3, 27, 1, 0, 0, 0, time.FixedZone("EET", 2*3600)), "2022-03-26T23:00:00Z", false},
+		{90 * time.Minute, "90m", false},
+		{-24 * time.Hour, "-1d", false},
+		{1500 * time.Millisecond, "1500ms", false},
+		{math.NaN(), "", true},
+		{math.Inf(1), "", true},
+		{[]string{"a"}, "", true},
+		{float32(1), "", true},
+	}
+	for _, tt := range tests {
+		got, err := Literal(tt.value)
+		if tt.err {
+			if err == nil {
+				t.Errorf("Literal(%#v) = %s, want error", tt.value, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Literal(%#v) = %s, %v, want %s", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestFilterEquals(t *testing.T) {
+	benign, _ := scanLiterals(t, From("bucket").FilterEquals("sensormac", "x").String())
+	for _, payload := range injectionPayloads {
+		q := From("bucket").FilterEquals("sensormac", payload)
+		if err := q.Err(); err != nil {
+			t.Fatalf("FilterEquals(%q): %v", payload, err)
+		}
+		code, literals := scanLiterals(t, q.String())
+		if code != benign {
+			t.Errorf("FilterEquals(%q) changed the query structure:\n%s", payload, q.String())
+		}
+		if literals[len(literals)-1] != payload {
+			t.Errorf("FilterEquals(%q) compares with %q", payload, literals[len(literals)-1])
+		}
+	}
+}
+
+func TestFilterEqualsInvalidColumn(t *testing.T) {
+	for _, column := range []string{"", `a"]) |> yield() //`, "a b", "${x}"} {
+		q := From("bucket").FilterEquals(column, "x")
+		if q.Err() == nil {
+			t.Errorf("FilterEquals(%q, ...) accepted an invalid column:\n%s", column, q.String())
+		}
+	}
+}
+
+func TestFilterIn(t *testing.T) {
+	benign, _ := scanLiterals(t, From("bucket").FilterIn("sensormac", []string{"x", "y"}).String())
+	for _, payload := range injectionPayloads {
+		values := []string{payload, "y"}
+		q := From("bucket").FilterIn("sensormac", values)
+		if err := q.Err(); err != nil {
+			t.Fatalf("FilterIn(%q): %v", payload, err)
+		}
+		code, literals := scanLiterals(t, q.String())
+		if code != benign {
+			t.Errorf("FilterIn(%q) changed the query structure:\n%s", payload, q.String())
+		}
+		got := literals[len(literals)-4:]
+		want := []string{"sensormac", payload, "sensormac", "y"}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("FilterIn(%q) literals = %q, want %q", payload, got, want)
+				break
+			}
+		}
+	}
+}
+
+func TestFilterInErrors(t *testing.T) {
+	if From("bucket").FilterIn("sensormac", nil).Err() == nil {
+		t.Error("FilterIn without values was accepted")
+	}
+	if From("bucket").FilterIn(`a"b`, []string{"x"}).Err() == nil {
+		t.Error("FilterIn with an invalid column was accepted")
+	}
+}
+
+func TestParameterized(t *testing.T) {
+	start := time.Date(2022, 10, 30, 0, 0, 0, 0, time.UTC)
+	stop := start.Add(24 * time.Hour)
+	for _, payload := range injectionPayloads {
+		q := From("bucket").
+			Range(start, stop).
+			FilterEquals("sensormac", payload).
+			AggregateWindow(Window{Every: time.Hour}, "mean", false)
+		text, params := q.Parameterized()
+
+		if strings.Contains(text, payload) && payload != `"` && payload != `\` {
+			t.Errorf("payload %q appears in the query text:\n%s", payload, text)
+		}
+		code, literals := scanLiterals(t, text)
+		if strings.Count(code, "|>") != 3 || strings.Count(code, "\n") != 3 {
+			t.Errorf("payload %q changed the query structure:\n%s", payload, text)
+		}
+		for _, l := range literals {
+			if l != "sensormac" {
+				t.Errorf("unexpected literal %q in query text:\n%s", l, text)
+			}
+		}
+
+		want := map[string]interface{}{
+			"p0": "bucket",
+			"p1": "2022-10-30T00:00:00Z",
+			"p2": "2022-10-31T00:00:00Z",
+			"p3": payload,
+		}
+		if len(params) != len(want) {
+			t.Fatalf("params = %v, want %v", params, want)
+		}
+		for name, v := range want {
+			if params[name] != v {
+				t.Errorf("params[%s] = %#v, want %#v", name, params[name], v)
+			}
+		}
+	}
+}
+
+func TestParameterizedTimes(t *testing.T) {
+	start := time.Date(2022, 3, 27, 3, 0, 0, 0, time.FixedZone("EEST", 3*3600))
+	stop := start.Add(time.Hour)
+	text, params := From("bucket").Range(start, stop).RangeSince(time.Hour).Parameterized()
+
+	if !strings.Contains(text, `range(start: time(v: params.p1), stop: time(v: params.p2))`) {
+		t.Errorf("times are not converted from parameters:\n%s", text)
+	}
+	if !strings.Contains(text, `range(start: -1h)`) {
+		t.Errorf("durations are not inlined:\n%s", text)
+	}
+	for name, want := range map[string]string{"p1": "2022-03-27T00:00:00Z", "p2": "2022-03-27T01:00:00Z"} {
+		if got, ok := params[name].(string); !ok || got != want {
+			t.Errorf("params[%s] = %#v, want %q", name, params[name], want)
+		}
+	}
+}
+
+func TestParameterizedNumbers(t *testing.T) {
+	text, params := From("bucket").
+		Add(`|> filter(fn: (r) => float(v: r._value) >= ? and float(v: r._value) <= ?)`, -80.0, 60.5).
+		Add(`|> map(fn: (r) => ({r with step: r.step + ?, count: r.count + ?}))`, 255, int64(-3)).
+		AggregateWindowQuantile(Window{Every: time.Hour}, 0.95, false).
+		Parameterized()
+
+	for _, want := range []string{
+		`float(v: r._value) >= -80.0 and float(v: r._value) <= 60.5`,
+		`r.step + 255, count: r.count + -3`,
+		`quantile(q: 0.95, column: column)`,
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("query lacks %s:\n%s", want, text)
+		}
+	}
+	if len(params) != 1 || params["p0"] != "bucket" {
+		t.Errorf("params = %v, want only the bucket", params)
+	}
+}
+
+func TestAddPlaceholderMismatch(t *testing.T) {
+	if New().Add(`x = ?`).Err() == nil {
+		t.Error("missing value was accepted")
+	}
+	if New().Add(`x = 1`, "a").Err() == nil {
+		t.Error("extra value was accepted")
+	}
+	if err := New().Add(`x = ?`, []int{1}).Err(); err == nil {
+		t.Error("unsupported value was accepted")
+	}
+}
+
+func TestErrIsFirstError(t *testing.T) {
+	q := New().FilterEquals("a b", "x").FilterIn("c", nil)
+	if err := q.Err(); err == nil || !strings.Contains(err.Error(), "a b") {
+		t.Errorf("Err() = %v, want the invalid identifier error", err)
+	}
+}
+
+func TestOption(t *testing.T) {
+	q := From("bucket").
+		Location("Europe/Helsinki").
+		Location("Europe/Helsinki").
+		Option(`now = () => ?`, time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)).
+		Location("Europe/Stockholm")
+	if err := q.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := `import "timezone"
+option location = timezone.location(name: "Europe/Stockholm")
+option now = () => 2022-01-01T00:00:00Z
+from(bucket: "bucket")`
+	if got := q.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	for _, template := range []string{`= 1`, `a b = 1`, `"x" = 1`} {
+		if New().Option(template).Err() == nil {
+			t.Errorf("Option(%q) was accepted", template)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+)
+
+// Gap is a run of consecutive aggregation windows without measurements.
+type Gap struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+
+	// Duration of the gap in seconds
+	Duration float64 `json:"duration"`
+}
+
+// RangeWithGaps holds aggregated measurements with null values for empty windows,
+// and the gaps formed by the empty windows.
+type RangeWithGaps struct {
+	Measurements []Measurement `json:"measurements"`
+	Gaps         []Gap         `json:"gaps"`
+}
+
+// maxGapWindows limits the number of windows in a range with gaps,
+// as each empty window is returned as a null point.
+const maxGapWindows = 10000
+
+// emptyMeasurement stands for an aggregation window without measurements.
+// Its value is encoded as null under the same key as values of the field.
+type emptyMeasurement struct {
+	sensorID string
+	field    string
+	unit     string
+	time     time.Time
+}
+
+func (m *emptyMeasurement) Measurement() string {
+	return m.field
+}
+
+func (m *emptyMeasurement) SensorID() string {
+	return m.sensorID
+}
+
+func (m *emptyMeasurement) Value() interface{} {
+	return nil
+}
+
+func (m *emptyMeasurement) Unit() string {
+	return m.unit
+}
+
+func (m *emptyMeasurement) Time() time.Time {
+	return m.time
+}
+
+func (m *emptyMeasurement) MarshalJSON() ([]byte, error) {
+	key := m.field
+	if ft, ok := fieldTypes[m.field]; ok {
+		key = ft.Key
+	}
+	return json.Marshal(map[string]interface{}{
+		"sensorID": m.sensorID,
+		key:        nil,
+		"unit":     m.unit,
+		"time":     m.time,
+	})
+}
+
+// windowCount returns an upper bound for the number of windows of query between its Start and Stop,
+// counting partial windows at both ends.
+func windowCount(query RangeQuery) int64 {
+	if query.Months > 0 {
+		start, stop := query.Start.UTC(), query.Stop.UTC()
+		months := (stop.Year()-start.Year())*12 + int(stop.Month()-start.Month())
+		return int64(months/query.Months) + 2
+	}
+	if query.Interval <= 0 {
+		return math.MaxInt64
+	}
+	return int64(query.Stop.Sub(query.Start)/query.Interval) + 2
+}
+
+// fillGaps adds an empty measurement for each window of query between its Start and Stop
+// without any of measurements, which must be aggregated with query and sorted by time.
+// Empty measurements are labelled with unit, the unit of measurements.
+// Windows are stamped with their stop time, the last one being cut short at Stop.
+// Consecutive empty windows are also returned as gaps.
+func fillGaps(query RangeQuery, measurements []Measurement, unit string) RangeWithGaps {
+	result := RangeWithGaps{
+		Measurements: make([]Measurement, 0, len(measurements)),
+		Gaps:         []Gap{},
+	}
+	w := query.window()
+	inGap := false
+
+	i := 0
+	for start := query.Start; start.Before(query.Stop); {
+		_, windowStop := w.bounds(start)
+		stop := windowStop
+		if stop.After(query.Stop) {
+			stop = query.Stop
+		}
+
+		// measurements are stamped with the stop of their window,
+		// which may or may not have been cut short at Stop
+		found := false
+		for ; i < len(measurements) && !measurements[i].Time().After(windowStop); i++ {
+			result.Measurements = append(result.Measurements, measurements[i])
+			found = true
+		}
+
+		switch {
+		case found:
+			inGap = false
+		case inGap:
+			gap := &result.Gaps[len(result.Gaps)-1]
+			gap.End = stop
+			gap.Duration = gap.End.Sub(gap.Start).Seconds()
+		default:
+			inGap = true
+			result.Gaps = append(result.Gaps, Gap{
+				Start:    start,
+				End:      stop,
+				Duration: stop.Sub(start).Seconds(),
+			})
+		}
+		if !found {
+			result.Measurements = append(result.Measurements, &emptyMeasurement{
+				sensorID: query.SensorID,
+				field:    query.Field,
+				unit:     unit,
+				time:     stop,
+			})
+		}
+		start = stop
+	}
+	// measurements past Stop, if the store's clock is ahead
+	result.Measurements = append(result.Measurements, measurements[i:]...)
+
+	return result
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestFillGapsEncodesNullsLikeValues(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	query := RangeQuery{
+		Field:    "batteryvoltage",
+		SensorID: "sensor",
+		Start:    start,
+		Stop:     start.Add(3 * time.Hour),
+		Interval: time.Hour,
+	}
+	m, err := NewMeasurement("batteryvoltage", "sensor", 2.9, start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := fillGaps(query, []Measurement{m}, "V")
+	if len(result.Measurements) != 3 || len(result.Gaps) != 1 {
+		t.Fatalf("got %d measurements and %d gaps, want 3 and 1", len(result.Measurements), len(result.Gaps))
+	}
+	for _, m := range result.Measurements {
+		data, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded map[string]interface{}
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := decoded["voltage"]; !ok {
+			t.Errorf("%s has no voltage key", data)
+		}
+		if decoded["unit"] != "V" {
+			t.Errorf("%s is not labelled with V", data)
+		}
+	}
+}
+
+func TestWindowCount(t *testing.T) {
+	start := time.Date(2022, 1, 31, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		query RangeQuery
+		min   int64
+	}{
+		{RangeQuery{Start: start, Stop: start.Add(24 * time.Hour), Interval: time.Hour}, 24},
+		{RangeQuery{Start: start, Stop: start.AddDate(1, 0, 0), Interval: time.Second}, 365 * 24 * 3600},
+		{RangeQuery{Start: start, Stop: start.AddDate(1, 0, 0), Months: 1}, 12},
+		{RangeQuery{Start: start, Stop: start.AddDate(0, 0, 1), Months: 1}, 1},
+	}
+	for _, tt := range tests {
+		n := windowCount(tt.query)
+		if n < tt.min || n > tt.min+2 {
+			t.Errorf("windowCount(%+v) = %d, want %d to %d", tt.query, n, tt.min, tt.min+2)
+		}
+		if n > maxGapWindows {
+			continue
+		}
+		if result := fillGaps(tt.query, nil, ""); int64(len(result.Measurements)) > n {
+			t.Errorf("windowCount(%+v) = %d, but there are %d windows", tt.query, n, len(result.Measurements))
+		}
+	}
+}
@@ -6,13 +6,13 @@ require (
 	github.com/google/uuid v1.3.0
 	github.com/gorilla/handlers v1.5.1
 	github.com/gorilla/mux v1.8.0
-	github.com/influxdata/influxdb-client-go/v2 v2.3.0
+	github.com/influxdata/influxdb-client-go/v2 v2.8.0
 	github.com/mattn/go-sqlite3 v1.14.8
 	golang.org/x/crypto v0.0.0-20210711020723-a769d52b0f97
 )
 
 require (
-	github.com/deepmap/oapi-codegen v1.6.0 // indirect
+	github.com/deepmap/oapi-codegen v1.8.2 // indirect
 	github.com/felixge/httpsnoop v1.0.1 // indirect
 	github.com/influxdata/line-protocol v0.0.0-20200327222509-2487e7298839 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
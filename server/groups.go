@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"time"
+)
+
+// GroupPoint summarises one aggregation window across the members of a group.
+// Each member contributes its own aggregate of the window, e.g. its mean,
+// and Mean, Min and Max are taken over those. Sensors is the number of members with data in the window.
+type GroupPoint struct {
+	Time    time.Time `json:"time"`
+	Mean    float64   `json:"mean"`
+	Min     float64   `json:"min"`
+	Max     float64   `json:"max"`
+	Sensors int       `json:"sensors"`
+}
+
+// GroupRange is the range of a field across the members of a group.
+// Members holds the series of each member when requested.
+type GroupRange struct {
+	Group   string                   `json:"group"`
+	Field   string                   `json:"field"`
+	Unit    string                   `json:"unit"`
+	Points  []GroupPoint             `json:"points"`
+	Members map[string][]Measurement `json:"members,omitempty"`
+}
+
+// groupRangeBySensor implements GroupRange of store with a Range query per sensor,
+// for stores which cannot query the sensors together.
+func groupRangeBySensor(ctx context.Context, store Store, query RangeQuery, sensorIDs []string) ([]Measurement, int, error) {
+	var (
+		measurements []Measurement
+		rejected     int
+	)
+	for _, id := range sensorIDs {
+		memberQuery := query
+		memberQuery.SensorID = id
+		series, n, err := store.Range(ctx, memberQuery)
+		if errors.Is(err, ErrNoData) {
+			continue
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		measurements = append(measurements, series...)
+		rejected += n
+	}
+	if len(measurements) == 0 {
+		return nil, 0, ErrNoData
+	}
+	return measurements, rejected, nil
+}
+
+// groupPoints summarises measurements of several sensors by window, in time order.
+func groupPoints(measurements []Measurement) []GroupPoint {
+	byTime := make(map[time.Time]*GroupPoint)
+	for _, m := range measurements {
+		v, ok := floatValue(m)
+		if !ok {
+			continue
+		}
+		t := m.Time()
+		p, ok := byTime[t]
+		if !ok {
+			p = &GroupPoint{Time: t, Min: math.Inf(1), Max: math.Inf(-1)}
+			byTime[t] = p
+		}
+		p.Mean += v
+		p.Min = math.Min(p.Min, v)
+		p.Max = math.Max(p.Max, v)
+		p.Sensors++
+	}
+
+	points := make([]GroupPoint, 0, len(byTime))
+	for _, p := range byTime {
+		p.Mean /= float64(p.Sensors)
+		points = append(points, *p)
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].Time.Before(points[j].Time) })
+	return points
+}
+
+// membersSeries splits measurements of several sensors into a series per sensor.
+func membersSeries(measurements []Measurement) map[string][]Measurement {
+	members := make(map[string][]Measurement)
+	for _, m := range measurements {
+		members[m.SensorID()] = append(members[m.SensorID()], m)
+	}
+	for _, series := range members {
+		sort.SliceStable(series, func(i, j int) bool { return series[i].Time().Before(series[j].Time()) })
+	}
+	return members
+}
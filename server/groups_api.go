@@ -0,0 +1,260 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LassiHeikkila/mokki-cloud/server/registry"
+)
+
+// HandleGroups lists sensor groups on GET and adds one on POST.
+// Adding requires the admin token.
+func HandleGroups(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	if !Authenticated(w, req) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	switch req.Method {
+	case http.MethodGet:
+		writeJSON(w, registry.Groups())
+	case http.MethodPost:
+		if !IsAdmin(req) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		group, err := readGroup(req)
+		if err != nil {
+			log.Println("error reading group from request:", err)
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		group, err = registry.AddGroup(group)
+		if err != nil {
+			writeGroupError(w, err)
+			return
+		}
+		b, err := json.Marshal(group)
+		if err != nil {
+			log.Println("error marshalling data:", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write(b)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleGroup returns a sensor group on GET, replaces it on PUT and removes it on DELETE.
+// Replacing and removing require the admin token.
+func HandleGroup(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	if !Authenticated(w, req) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	name, err := getGroupFromPath(req.URL.Path, 4)
+	if err != nil {
+		log.Printf("error getting group from request path (%s): %s\n", req.URL.Path, err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if req.Method == http.MethodPut || req.Method == http.MethodDelete {
+		if !IsAdmin(req) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+	}
+	switch req.Method {
+	case http.MethodGet:
+		group, ok := registry.GetGroup(name)
+		if !ok {
+			writeGroupError(w, registry.ErrGroupNotFound)
+			return
+		}
+		writeJSON(w, group)
+	case http.MethodPut:
+		group, err := readGroup(req)
+		if err != nil {
+			log.Println("error reading group from request:", err)
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		group.Name = name
+		group, err = registry.UpdateGroup(group)
+		if err != nil {
+			writeGroupError(w, err)
+			return
+		}
+		writeJSON(w, group)
+	case http.MethodDelete:
+		if err := registry.RemoveGroup(name); err != nil {
+			writeGroupError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleGroupRange returns the mean, minimum and maximum of each window across the members of a group.
+// Each member is first aggregated over the window with agg, mean by default.
+// With members=true the series of each member is included too.
+func (h *Handler) HandleGroupRange(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	if !Authenticated(w, req) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	name, err := getGroupFromPath(req.URL.Path, 6)
+	if err != nil {
+		log.Printf("error getting group from request path (%s): %s\n", req.URL.Path, err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	field, err := getFieldFromGroupPath(req.URL.Path)
+	if err != nil {
+		log.Printf("error getting field from request path (%s): %s\n", req.URL.Path, err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	group, ok := registry.GetGroup(name)
+	if !ok {
+		writeGroupError(w, registry.ErrGroupNotFound)
+		return
+	}
+	location, err := h.getLocationFromQuery(req.URL.Query())
+	if err != nil {
+		log.Println("error getting time zone from query:", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	start, stop, since, err := getTimeRangeFromQuery(req.URL.Query(), time.Now().In(location))
+	if err != nil {
+		log.Println("error getting time range from query:", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	defaultInterval := autoInterval(stop.Sub(start), targetRangePoints)
+	interval, months, err := getIntervalFromQueryOrDefault(req.URL.Query(), "interval", defaultInterval)
+	if err != nil || (interval <= 0 && months <= 0) {
+		log.Println("invalid interval in query:", req.URL.Query().Get("interval"))
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	agg, err := fieldAggregate(field, req.URL.Query().Get("agg"))
+	if err != nil {
+		log.Println("error getting aggregate from query:", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	raw, despike, err := getFilteringFromQuery(req.URL.Query())
+	if err != nil {
+		log.Println("error getting filtering from query:", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	units, err := ParseUnits(req.URL.Query().Get("units"))
+	if err != nil {
+		log.Println("error getting units from query:", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	withMembers, err := getBoolFromQueryOrDefault(req.URL.Query(), "members", false)
+	if err != nil {
+		log.Println("error getting members from query:", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	query := RangeQuery{
+		Field:     field,
+		Start:     start,
+		Stop:      stop,
+		Since:     since,
+		Interval:  interval,
+		Aggregate: agg,
+		Months:    months,
+		Location:  location,
+		Raw:       raw,
+		Despike:   despike,
+	}
+
+	measurements, rejected, err := withUnits(h.store, units).GroupRange(req.Context(), query, group.Members)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	data := GroupRange{
+		Group:  group.Name,
+		Field:  field,
+		Unit:   units.unit(field),
+		Points: groupPoints(measurements),
+	}
+	if withMembers {
+		data.Members = membersSeries(measurements)
+	}
+
+	w.Header().Set(rejectedHeader, strconv.Itoa(rejected))
+	w.Header().Set(aggregateHeader, agg.String())
+	w.Header().Set(intervalHeader, formatInterval(interval, months))
+	writeJSON(w, data)
+}
+
+func readGroup(req *http.Request) (registry.Group, error) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return registry.Group{}, err
+	}
+	var group registry.Group
+	if err := json.Unmarshal(b, &group); err != nil {
+		return registry.Group{}, err
+	}
+	return group, nil
+}
+
+func writeGroupError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, registry.ErrGroupNotFound):
+		http.Error(w, "group not found", http.StatusNotFound)
+	case errors.Is(err, registry.ErrGroupExists):
+		http.Error(w, "group already exists", http.StatusConflict)
+	case registry.IsValidationError(err):
+		log.Println("invalid group:", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+	default:
+		log.Println("error storing group:", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+	}
+}
+
+func getGroupFromPath(path string, segmentCount int) (string, error) {
+	// /api/groups/{group} or /api/groups/{group}/{field}/range
+	// group is third item, but split counts the empty value before the first /
+	segments := strings.Split(path, "/")
+	if len(segments) != segmentCount || segments[3] == "" {
+		return "", fmt.Errorf("malformed path: %v", segments)
+	}
+	return segments[3], nil
+}
+
+func getFieldFromGroupPath(path string) (string, error) {
+	// /api/groups/{group}/{field}/range
+	// field is fourth item, but split counts the empty value before the first /
+	segments := strings.Split(path, "/")
+	if len(segments) != 6 || segments[4] == "" {
+		return "", fmt.Errorf("malformed path: %v", segments)
+	}
+	return segments[4], nil
+}
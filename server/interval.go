@@ -0,0 +1,114 @@
+package server
+
+import (
+	"time"
+)
+
+// targetRangePoints is the number of points range queries aim for when no interval is given.
+const targetRangePoints = 500
+
+// niceIntervals are the aggregation intervals chosen from when no interval is given.
+var niceIntervals = []time.Duration{
+	time.Minute,
+	2 * time.Minute,
+	5 * time.Minute,
+	10 * time.Minute,
+	15 * time.Minute,
+	30 * time.Minute,
+	time.Hour,
+	2 * time.Hour,
+	3 * time.Hour,
+	6 * time.Hour,
+	12 * time.Hour,
+	24 * time.Hour,
+	2 * 24 * time.Hour,
+	7 * 24 * time.Hour,
+	14 * 24 * time.Hour,
+	30 * 24 * time.Hour,
+}
+
+// autoInterval picks the shortest nice interval which splits span into at most points windows.
+func autoInterval(span time.Duration, points int) time.Duration {
+	if points < 1 {
+		points = 1
+	}
+	for _, interval := range niceIntervals {
+		windows := span / interval
+		if span%interval != 0 {
+			// a partial window at the end counts as a whole one
+			windows++
+		}
+		if windows <= time.Duration(points) {
+			return interval
+		}
+	}
+	return niceIntervals[len(niceIntervals)-1]
+}
+
+const (
+	day  = 24 * time.Hour
+	week = 7 * day
+)
+
+// window divides time into aggregation windows the same way Flux aggregateWindow does.
+// Windows of whole days are aligned to midnight in location, and windows of
+// whole weeks start on Monday. Shorter windows are aligned to the Unix epoch
+// on the local clock, e.g. hourly windows start at half past in UTC in Asia/Kolkata.
+type window struct {
+	every    time.Duration
+	months   int
+	location *time.Location
+}
+
+func (q RangeQuery) window() window {
+	return window{
+		every:    q.Interval,
+		months:   q.Months,
+		location: q.Location,
+	}
+}
+
+// bounds returns the start and stop of the window containing t.
+func (w window) bounds(t time.Time) (time.Time, time.Time) {
+	loc := w.location
+	if loc == nil {
+		loc = time.UTC
+	}
+	local := t.In(loc)
+
+	switch {
+	case w.months > 0:
+		monthsSinceEpoch := (local.Year()-1970)*12 + int(local.Month()-time.January)
+		startMonth := floorDiv(monthsSinceEpoch, w.months) * w.months
+		// time.Date normalizes months beyond December
+		start := time.Date(1970, time.January+time.Month(startMonth), 1, 0, 0, 0, 0, loc)
+		return start, start.AddDate(0, w.months, 0)
+	case w.every >= day && w.every%day == 0:
+		days := int(w.every / day)
+		daysSinceEpoch := int(time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.UTC).Unix() / int64(day/time.Second))
+		shift := 0
+		if days%7 == 0 {
+			// the epoch is a Thursday, the first Monday after it is four days later
+			shift = 4
+		}
+		startDay := floorDiv(daysSinceEpoch-shift, days)*days + shift
+		// days are counted as calendar days, so DST changes don't shift window boundaries
+		start := time.Date(1970, time.January, 1+startDay, 0, 0, 0, 0, loc)
+		return start, start.AddDate(0, 0, days)
+	default:
+		// align on the local clock, as Flux does with a location
+		_, offset := local.Zone()
+		shift := time.Duration(offset) * time.Second
+		start := windowStart(t.Add(shift), w.every).Add(-shift)
+		return start, start.Add(w.every)
+	}
+}
+
+// floorDiv divides rounding towards negative infinity.
+func floorDiv(a, b int) int {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
@@ -0,0 +1,333 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/LassiHeikkila/mokki-cloud/server/flux"
+)
+
+func loadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestFloorDiv(t *testing.T) {
+	tests := []struct {
+		a, b, want int
+	}{
+		{7, 2, 3},
+		{6, 2, 3},
+		{0, 3, 0},
+		{-1, 7, -1},
+		{-6, 2, -3},
+		{-7, 2, -4},
+		{7, -2, -4},
+		{-7, -2, 3},
+	}
+	for _, tt := range tests {
+		if got := floorDiv(tt.a, tt.b); got != tt.want {
+			t.Errorf("floorDiv(%d, %d) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// In Europe/Helsinki DST starts on the last Sunday of March at 03:00 EET,
+// and ends on the last Sunday of October at 04:00 EEST.
+func TestWindowBoundsAcrossDST(t *testing.T) {
+	helsinki := loadLocation(t, "Europe/Helsinki")
+	local := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, helsinki)
+	}
+	utc := func(value string) time.Time {
+		t.Helper()
+		tm, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+
+	tests := []struct {
+		name        string
+		window      window
+		t           time.Time
+		start, stop time.Time
+		length      time.Duration
+	}{
+		{
+			name:   "day DST starts",
+			window: window{every: day, location: helsinki},
+			t:      local(2022, time.March, 27, 12, 0),
+			start:  utc("2022-03-26T22:00:00Z"),
+			stop:   utc("2022-03-27T21:00:00Z"),
+			length: 23 * time.Hour,
+		},
+		{
+			name:   "day DST starts, in the skipped hour",
+			window: window{every: day, location: helsinki},
+			t:      utc("2022-03-27T01:00:00Z"),
+			start:  utc("2022-03-26T22:00:00Z"),
+			stop:   utc("2022-03-27T21:00:00Z"),
+			length: 23 * time.Hour,
+		},
+		{
+			name:   "day before DST starts, last second",
+			window: window{every: day, location: helsinki},
+			t:      utc("2022-03-26T21:59:59Z"),
+			start:  utc("2022-03-25T22:00:00Z"),
+			stop:   utc("2022-03-26T22:00:00Z"),
+			length: day,
+		},
+		{
+			name:   "day after DST starts",
+			window: window{every: day, location: helsinki},
+			t:      utc("2022-03-27T21:00:00Z"),
+			start:  utc("2022-03-27T21:00:00Z"),
+			stop:   utc("2022-03-28T21:00:00Z"),
+			length: day,
+		},
+		{
+			name:   "day DST ends",
+			window: window{every: day, location: helsinki},
+			t:      local(2022, time.October, 30, 12, 0),
+			start:  utc("2022-10-29T21:00:00Z"),
+			stop:   utc("2022-10-30T22:00:00Z"),
+			length: 25 * time.Hour,
+		},
+		{
+			name:   "day DST ends, first of the repeated hour",
+			window: window{every: day, location: helsinki},
+			t:      utc("2022-10-30T00:30:00Z"),
+			start:  utc("2022-10-29T21:00:00Z"),
+			stop:   utc("2022-10-30T22:00:00Z"),
+			length: 25 * time.Hour,
+		},
+		{
+			name:   "day DST ends, second of the repeated hour",
+			window: window{every: day, location: helsinki},
+			t:      utc("2022-10-30T01:30:00Z"),
+			start:  utc("2022-10-29T21:00:00Z"),
+			stop:   utc("2022-10-30T22:00:00Z"),
+			length: 25 * time.Hour,
+		},
+		{
+			name:   "two days across DST end",
+			window: window{every: 2 * day, location: helsinki},
+			t:      local(2022, time.October, 30, 12, 0),
+			start:  local(2022, time.October, 29, 0, 0),
+			stop:   local(2022, time.October, 31, 0, 0),
+			length: 2*day + time.Hour,
+		},
+		{
+			name:   "week DST starts",
+			window: window{every: week, location: helsinki},
+			t:      local(2022, time.March, 27, 12, 0),
+			start:  local(2022, time.March, 21, 0, 0),
+			stop:   local(2022, time.March, 28, 0, 0),
+			length: week - time.Hour,
+		},
+		{
+			name:   "week DST starts, Monday",
+			window: window{every: week, location: helsinki},
+			t:      local(2022, time.March, 28, 0, 0),
+			start:  local(2022, time.March, 28, 0, 0),
+			stop:   local(2022, time.April, 4, 0, 0),
+			length: week,
+		},
+		{
+			name:   "week DST ends",
+			window: window{every: week, location: helsinki},
+			t:      local(2022, time.October, 30, 23, 59),
+			start:  local(2022, time.October, 24, 0, 0),
+			stop:   local(2022, time.October, 31, 0, 0),
+			length: week + time.Hour,
+		},
+		{
+			name:   "month DST starts",
+			window: window{months: 1, location: helsinki},
+			t:      local(2022, time.March, 27, 12, 0),
+			start:  utc("2022-02-28T22:00:00Z"),
+			stop:   utc("2022-03-31T21:00:00Z"),
+			length: 31*day - time.Hour,
+		},
+		{
+			name:   "month DST ends",
+			window: window{months: 1, location: helsinki},
+			t:      local(2022, time.October, 30, 3, 30),
+			start:  utc("2022-09-30T21:00:00Z"),
+			stop:   utc("2022-10-31T22:00:00Z"),
+			length: 31*day + time.Hour,
+		},
+		{
+			name:   "quarter DST ends",
+			window: window{months: 3, location: helsinki},
+			t:      local(2022, time.October, 30, 3, 30),
+			start:  local(2022, time.October, 1, 0, 0),
+			stop:   local(2023, time.January, 1, 0, 0),
+			length: 92*day + time.Hour,
+		},
+		{
+			name:   "hour DST starts",
+			window: window{every: time.Hour, location: helsinki},
+			t:      utc("2022-03-27T00:59:59Z"),
+			start:  utc("2022-03-27T00:00:00Z"),
+			stop:   utc("2022-03-27T01:00:00Z"),
+			length: time.Hour,
+		},
+		{
+			name:   "day before the epoch",
+			window: window{every: day, location: helsinki},
+			t:      local(1969, time.December, 31, 12, 0),
+			start:  local(1969, time.December, 31, 0, 0),
+			stop:   local(1970, time.January, 1, 0, 0),
+			length: day,
+		},
+		{
+			name:   "month before the epoch",
+			window: window{months: 1},
+			t:      time.Date(1969, time.March, 27, 12, 0, 0, 0, time.UTC),
+			start:  time.Date(1969, time.March, 1, 0, 0, 0, 0, time.UTC),
+			stop:   time.Date(1969, time.April, 1, 0, 0, 0, 0, time.UTC),
+			length: 31 * day,
+		},
+	}
+	for _, tt := range tests {
+		start, stop := tt.window.bounds(tt.t)
+		if !start.Equal(tt.start) || !stop.Equal(tt.stop) {
+			t.Errorf("%s: bounds(%s) = [%s, %s), want [%s, %s)", tt.name, tt.t, start, stop, tt.start, tt.stop)
+		}
+		if stop.Sub(start) != tt.length {
+			t.Errorf("%s: window is %s long, want %s", tt.name, stop.Sub(start), tt.length)
+		}
+		if tt.t.Before(start) || !tt.t.Before(stop) {
+			t.Errorf("%s: %s is not within [%s, %s)", tt.name, tt.t, start, stop)
+		}
+	}
+}
+
+// Consecutive windows must tile time without gaps or overlaps also across DST changes.
+func TestWindowsAreContiguousAcrossDST(t *testing.T) {
+	helsinki := loadLocation(t, "Europe/Helsinki")
+	windows := []window{
+		{every: day, location: helsinki},
+		{every: 3 * day, location: helsinki},
+		{every: week, location: helsinki},
+		{months: 1, location: helsinki},
+	}
+	for _, w := range windows {
+		for _, month := range []time.Month{time.March, time.October} {
+			t0 := time.Date(2022, month, 1, 0, 0, 0, 0, helsinki)
+			_, stop := w.bounds(t0)
+			for t1 := t0; t1.Before(t0.AddDate(0, 2, 0)); t1 = t1.Add(30 * time.Minute) {
+				start, next := w.bounds(t1)
+				if start.After(t1) || !next.After(t1) {
+					t.Fatalf("%+v: %s is not within [%s, %s)", w, t1, start, next)
+				}
+				if next.Equal(stop) {
+					continue
+				}
+				if !start.Equal(stop) {
+					t.Fatalf("%+v: window [%s, %s) does not continue from %s", w, start, next, stop)
+				}
+				if local := start.In(helsinki); local.Hour() != 0 || local.Minute() != 0 {
+					t.Fatalf("%+v: window starts at %s, not at local midnight", w, local)
+				}
+				stop = next
+			}
+		}
+	}
+}
+
+func TestAggregateWindowSetsLocationOnce(t *testing.T) {
+	helsinki := loadLocation(t, "Europe/Helsinki")
+	rq := RangeQuery{Interval: week, Location: helsinki}
+	query := flux.From("bucket")
+	for _, agg := range []Aggregate{{Function: "min"}, AggregateMean, {Function: "max"}} {
+		query = aggregateWindow(query, rq, agg)
+	}
+	if err := query.Err(); err != nil {
+		t.Fatal(err)
+	}
+	text := query.String()
+	if n := strings.Count(text, "option location"); n != 1 {
+		t.Errorf("location is set %d times:\n%s", n, text)
+	}
+	if n := strings.Count(text, "offset: 4d"); n != 3 {
+		t.Errorf("weekly windows are not shifted to Monday:\n%s", text)
+	}
+}
+
+// Windows shorter than a day are aligned to the local clock, which is off whole hours in some zones.
+func TestSubDayWindowsFollowLocalClock(t *testing.T) {
+	kolkata := loadLocation(t, "Asia/Kolkata")
+	tests := []struct {
+		every       time.Duration
+		t           time.Time
+		start, stop time.Time
+	}{
+		{time.Hour, time.Date(2022, 10, 30, 10, 45, 0, 0, kolkata), time.Date(2022, 10, 30, 10, 0, 0, 0, kolkata), time.Date(2022, 10, 30, 11, 0, 0, 0, kolkata)},
+		{6 * time.Hour, time.Date(2022, 10, 30, 5, 59, 0, 0, kolkata), time.Date(2022, 10, 30, 0, 0, 0, 0, kolkata), time.Date(2022, 10, 30, 6, 0, 0, 0, kolkata)},
+		{15 * time.Minute, time.Date(2022, 10, 30, 10, 50, 0, 0, kolkata), time.Date(2022, 10, 30, 10, 45, 0, 0, kolkata), time.Date(2022, 10, 30, 11, 0, 0, 0, kolkata)},
+	}
+	for _, tt := range tests {
+		w := window{every: tt.every, location: kolkata}
+		start, stop := w.bounds(tt.t)
+		if !start.Equal(tt.start) || !stop.Equal(tt.stop) {
+			t.Errorf("%s: bounds(%s) = [%s, %s), want [%s, %s)", tt.every, tt.t, start, stop, tt.start, tt.stop)
+		}
+	}
+
+	// without a location the windows stay aligned to UTC
+	start, _ := window{every: time.Hour}.bounds(time.Date(2022, 10, 30, 10, 45, 0, 0, kolkata))
+	if want := time.Date(2022, 10, 30, 5, 0, 0, 0, time.UTC); !start.Equal(want) {
+		t.Errorf("UTC window starts at %s, want %s", start, want)
+	}
+}
+
+func TestLoadLocationRejectsLocal(t *testing.T) {
+	if _, err := LoadLocation("Local"); err == nil {
+		t.Error("Local was accepted")
+	}
+	if loc, err := LoadLocation("Asia/Kolkata"); err != nil || loc.String() != "Asia/Kolkata" {
+		t.Errorf("LoadLocation(Asia/Kolkata) = %v, %v", loc, err)
+	}
+}
+
+func TestAutoInterval(t *testing.T) {
+	tests := []struct {
+		span   time.Duration
+		points int
+		want   time.Duration
+	}{
+		{0, targetRangePoints, time.Minute},
+		{time.Hour, targetRangePoints, time.Minute},
+		{500 * time.Minute, targetRangePoints, time.Minute},
+		{500*time.Minute + time.Nanosecond, targetRangePoints, 2 * time.Minute},
+		{1000 * time.Minute, targetRangePoints, 2 * time.Minute},
+		{1000*time.Minute + time.Second, targetRangePoints, 5 * time.Minute},
+		{day, targetRangePoints, 5 * time.Minute},
+		{week, targetRangePoints, 30 * time.Minute},
+		{30 * day, targetRangePoints, 2 * time.Hour},
+		{365 * day, targetRangePoints, 24 * time.Hour},
+		{500 * day, targetRangePoints, 24 * time.Hour},
+		{500*day + time.Second, targetRangePoints, 2 * day},
+		{10 * 365 * day, targetRangePoints, 14 * day},
+		{100 * 365 * day, targetRangePoints, 30 * day},
+		{day, 1, day},
+		{day + time.Nanosecond, 1, 2 * day},
+		{day, 0, day},
+		{day, -1, day},
+	}
+	for _, tt := range tests {
+		if got := autoInterval(tt.span, tt.points); got != tt.want {
+			t.Errorf("autoInterval(%s, %d) = %s, want %s", tt.span, tt.points, got, tt.want)
+		}
+	}
+}
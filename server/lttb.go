@@ -0,0 +1,66 @@
+package server
+
+import (
+	"math"
+	"time"
+)
+
+// sample is a compact representation of a measurement used while downsampling.
+type sample struct {
+	t time.Time
+	v float64
+}
+
+// lttb downsamples samples to at most threshold samples using the
+// Largest-Triangle-Three-Buckets algorithm, which keeps the samples
+// contributing most to the visual shape of the series, such as peaks and troughs.
+// Samples must be in time order. First and last samples are always kept.
+func lttb(samples []sample, threshold int) []sample {
+	if threshold >= len(samples) || threshold < 3 {
+		return samples
+	}
+
+	downsampled := make([]sample, 0, threshold)
+	downsampled = append(downsampled, samples[0])
+
+	// first and last samples are kept as is, the rest are divided into buckets
+	bucketSize := float64(len(samples)-2) / float64(threshold-2)
+	selected := 0
+	for bucket := 0; bucket < threshold-2; bucket++ {
+		start := int(math.Floor(float64(bucket)*bucketSize)) + 1
+		end := int(math.Floor(float64(bucket+1)*bucketSize)) + 1
+
+		// the third point of the triangle is the average of the next bucket
+		nextStart := end
+		nextEnd := int(math.Floor(float64(bucket+2)*bucketSize)) + 1
+		if nextEnd > len(samples) {
+			nextEnd = len(samples)
+		}
+		var avgX, avgY float64
+		for _, s := range samples[nextStart:nextEnd] {
+			avgX += x(s)
+			avgY += s.v
+		}
+		n := float64(nextEnd - nextStart)
+		avgX /= n
+		avgY /= n
+
+		a := samples[selected]
+		maxArea := -1.0
+		for i := start; i < end; i++ {
+			area := math.Abs((x(a)-avgX)*(samples[i].v-a.v) - (x(a)-x(samples[i]))*(avgY-a.v))
+			if area > maxArea {
+				maxArea = area
+				selected = i
+			}
+		}
+		downsampled = append(downsampled, samples[selected])
+	}
+
+	return append(downsampled, samples[len(samples)-1])
+}
+
+// x returns the time of s in seconds, used as the x coordinate in lttb.
+func x(s sample) float64 {
+	return float64(s.t.UnixNano()) / float64(time.Second)
+}
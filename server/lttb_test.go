@@ -0,0 +1,69 @@
+package server
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func samplesOf(start time.Time, values ...float64) []sample {
+	samples := make([]sample, len(values))
+	for i, v := range values {
+		samples[i] = sample{t: start.Add(time.Duration(i) * time.Minute), v: v}
+	}
+	return samples
+}
+
+func TestLTTBKeepsEndsWithinThreshold(t *testing.T) {
+	start := time.Date(2022, 10, 30, 0, 0, 0, 0, time.UTC)
+	values := make([]float64, 1000)
+	for i := range values {
+		values[i] = math.Sin(float64(i) / 20)
+	}
+	samples := samplesOf(start, values...)
+
+	for _, threshold := range []int{3, 4, 10, 99, 500, 999} {
+		out := lttb(samples, threshold)
+		if len(out) > threshold {
+			t.Errorf("lttb to %d returned %d samples", threshold, len(out))
+		}
+		if out[0] != samples[0] || out[len(out)-1] != samples[len(samples)-1] {
+			t.Errorf("lttb to %d returned %v ... %v, want the first and last samples", threshold, out[0], out[len(out)-1])
+		}
+		for i := 1; i < len(out); i++ {
+			if !out[i].t.After(out[i-1].t) {
+				t.Errorf("lttb to %d returned samples out of time order at %d", threshold, i)
+				break
+			}
+		}
+	}
+}
+
+func TestLTTBKeepsShortSeries(t *testing.T) {
+	start := time.Date(2022, 10, 30, 0, 0, 0, 0, time.UTC)
+	samples := samplesOf(start, 1, 5, 2, 4, 3)
+	for _, threshold := range []int{0, 2, 5, 6, 100} {
+		if out := lttb(samples, threshold); !reflect.DeepEqual(out, samples) {
+			t.Errorf("lttb to %d returned %v, want the series unchanged", threshold, out)
+		}
+	}
+}
+
+func TestLTTBKeepsSpike(t *testing.T) {
+	start := time.Date(2022, 10, 30, 0, 0, 0, 0, time.UTC)
+	values := make([]float64, 1000)
+	for i := range values {
+		values[i] = 20
+	}
+	values[437] = 80
+	samples := samplesOf(start, values...)
+
+	out := lttb(samples, 20)
+	for _, s := range out {
+		if s == samples[437] {
+			return
+		}
+	}
+	t.Errorf("lttb to 20 returned %v, want the spike at %s kept", out, samples[437].t)
+}
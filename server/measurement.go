@@ -1,6 +1,9 @@
 package server
 
 import (
+	"encoding/json"
+	"errors"
+	"math"
 	"time"
 )
 
@@ -8,161 +11,113 @@ type Measurement interface {
 	SensorID() string
 	Measurement() string
 	Value() interface{}
+	// Unit returns the unit of Value, or an empty string if the value has no unit.
+	Unit() string
 	Time() time.Time
 }
 
-type BatteryVoltageMeasurement struct {
-	SensorID_ string `json:"sensorID"`
-
-	// Voltage given in volts
-	Voltage_ float64 `json:"voltage"`
-
-	// Time when measurement was recorded
-	Time_ time.Time `json:"time"`
-}
-
-func (m *BatteryVoltageMeasurement) Measurement() string {
-	return "batteryvoltage"
-}
-
-func (m *BatteryVoltageMeasurement) SensorID() string {
-	return m.SensorID_
-}
-
-func (m *BatteryVoltageMeasurement) Value() interface{} {
-	return m.Voltage_
-}
-
-func (m *BatteryVoltageMeasurement) Time() time.Time {
-	return m.Time_
-}
-
-type TemperatureMeasurement struct {
-	SensorID_ string `json:"sensorID"`
-
-	// Temperature given in Celsius
-	Temperature_ float64 `json:"temperature"`
-
-	// Time when measurement was recorded
-	Time_ time.Time `json:"time"`
-}
-
-func (m *TemperatureMeasurement) Measurement() string {
-	return "temperature"
-}
-
-func (m *TemperatureMeasurement) SensorID() string {
-	return m.SensorID_
-}
-
-func (m *TemperatureMeasurement) Value() interface{} {
-	return m.Temperature_
-}
-
-func (m *TemperatureMeasurement) Time() time.Time {
-	return m.Time_
-}
-
-type HumidityMeasurement struct {
-	SensorID_ string `json:"sensorID"`
-
-	// Humidity given in percent
-	Humidity_ float64 `json:"humidity"`
-
-	// Time when measurement was recorded
-	Time_ time.Time `json:"time"`
-}
-
-func (m *HumidityMeasurement) Measurement() string {
-	return "humidity"
-}
-
-func (m *HumidityMeasurement) SensorID() string {
-	return m.SensorID_
-}
-
-func (m *HumidityMeasurement) Value() interface{} {
-	return m.Humidity_
-}
-
-func (m *HumidityMeasurement) Time() time.Time {
-	return m.Time_
-}
-
-type PressureMeasurement struct {
-	SensorID_ string `json:"sensorID"`
-
-	// Pressure given in Pascal
-	Pressure_ int `json:"pressure"`
-
-	// Time when measurement was recorded
-	Time_ time.Time `json:"time"`
-}
-
-func (m *PressureMeasurement) Measurement() string {
-	return "pressure"
-}
-
-func (m *PressureMeasurement) SensorID() string {
-	return m.SensorID_
-}
-
-func (m *PressureMeasurement) Value() interface{} {
-	return m.Pressure_
-}
-
-func (m *PressureMeasurement) Time() time.Time {
-	return m.Time_
-}
-
-type CO2Measurement struct {
-	SensorID_ string `json:"sensorID"`
-
-	// CO2 concentration is given in ppm (parts per million)
-	CO2_ int `json:"co2"`
-
-	// Time when measurement was recorded
-	Time_ time.Time `json:"time"`
-}
-
-func (m *CO2Measurement) Measurement() string {
-	return "co2"
-}
-
-func (m *CO2Measurement) SensorID() string {
-	return m.SensorID_
-}
-
-func (m *CO2Measurement) Value() interface{} {
-	return m.CO2_
-}
-
-func (m *CO2Measurement) Time() time.Time {
-	return m.Time_
-}
-
-type PM2p5Measurement struct {
-	SensorID_ string `json:"sensorID"`
-
-	// Particular matter density given in micrograms per cubic meter
-	PM2p5_ float64 `json:"pm2p5"`
-
-	// Time when measurement was recorded
-	Time_ time.Time `json:"time"`
-}
-
-func (m *PM2p5Measurement) Measurement() string {
-	return "pm2p5"
-}
-
-func (m *PM2p5Measurement) SensorID() string {
-	return m.SensorID_
-}
-
-func (m *PM2p5Measurement) Value() interface{} {
-	return m.PM2p5_
-}
-
-func (m *PM2p5Measurement) Time() time.Time {
-	return m.Time_
+// NewMeasurement creates a Measurement of field, which must be registered, see FieldType.
+// Values of integer fields are rounded.
+func NewMeasurement(field, sensorID string, value float64, t time.Time) (Measurement, error) {
+	if field == "" {
+		return nil, errors.New("empty field")
+	}
+	ft, ok := fieldTypes[field]
+	if !ok {
+		return nil, errors.New("unknown field: " + field)
+	}
+	m := &measurement{
+		sensorID: sensorID,
+		field:    ft.Name,
+		key:      ft.Key,
+		value:    value,
+		unit:     ft.Unit,
+		time:     t,
+	}
+	if ft.Kind == KindInt {
+		m.value = int(math.Round(value))
+	}
+	return m, nil
+}
+
+// floatValue returns the value of m as float64.
+func floatValue(m Measurement) (float64, bool) {
+	switch v := m.Value().(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// measurement is a value of a field reported by a sensor, or computed from such values.
+// It is encoded with the value under the key of its field type, e.g.
+// {"sensorID": "AA:BB:CC:DD:EE:FF", "temperature": 21.5, "unit": "°C", "time": "2022-01-01T00:00:00Z"}.
+type measurement struct {
+	sensorID string
+	field    string
+	key      string
+
+	// value is either int or float64, depending on the kind of the field
+	value interface{}
+	unit  string
+
+	// time when measurement was recorded
+	time time.Time
+}
+
+func (m *measurement) Measurement() string {
+	return m.field
+}
+
+func (m *measurement) SensorID() string {
+	return m.sensorID
+}
+
+func (m *measurement) Value() interface{} {
+	return m.value
+}
+
+func (m *measurement) Unit() string {
+	return m.unit
+}
+
+func (m *measurement) Time() time.Time {
+	return m.time
+}
+
+func (m *measurement) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"sensorID": m.sensorID,
+		m.key:      m.value,
+		"unit":     m.unit,
+		"time":     m.time,
+	})
+}
+
+// withTime returns a copy of m recorded at t.
+func (m *measurement) withTime(t time.Time) *measurement {
+	c := *m
+	c.time = t
+	return &c
+}
+
+// asMeasurement returns m as *measurement, copying it if it is some other Measurement.
+func asMeasurement(m Measurement) *measurement {
+	if mm, ok := m.(*measurement); ok {
+		return mm
+	}
+	key := m.Measurement()
+	if ft, ok := fieldTypes[key]; ok {
+		key = ft.Key
+	}
+	return &measurement{
+		sensorID: m.SensorID(),
+		field:    m.Measurement(),
+		key:      key,
+		value:    m.Value(),
+		unit:     m.Unit(),
+		time:     m.Time(),
+	}
 }
@@ -0,0 +1,397 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store keeping measurements in memory.
+// It is meant for tests and local development without InfluxDB.
+type MemoryStore struct {
+	mu sync.RWMutex
+
+	// sensor ID -> field -> measurements sorted by time
+	data map[string]map[string][]Measurement
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		data: make(map[string]map[string][]Measurement),
+	}
+}
+
+// Add stores given measurements, keeping each series sorted by time.
+func (s *MemoryStore) Add(measurements ...Measurement) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, m := range measurements {
+		if m == nil {
+			continue
+		}
+		fields, ok := s.data[m.SensorID()]
+		if !ok {
+			fields = make(map[string][]Measurement)
+			s.data[m.SensorID()] = fields
+		}
+		series := append(fields[m.Measurement()], m)
+		sort.SliceStable(series, func(i, j int) bool {
+			return series[i].Time().Before(series[j].Time())
+		})
+		fields[m.Measurement()] = series
+	}
+}
+
+func (s *MemoryStore) Latest(ctx context.Context, field, id string) (Measurement, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	series := s.series(id, field)
+	if len(series) == 0 {
+		return nil, ErrNoData
+	}
+	return series[len(series)-1], nil
+}
+
+func (s *MemoryStore) Range(ctx context.Context, query RangeQuery) ([]Measurement, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	inRange, rejected := s.between(query)
+	if len(inRange) == 0 {
+		return nil, 0, ErrNoData
+	}
+	if query.Interval <= 0 && query.Months <= 0 {
+		return inRange, rejected, nil
+	}
+
+	aggregated, err := aggregateWindows(query.Field, query.SensorID, inRange, query.window(), query.Aggregate)
+	if err != nil {
+		return nil, 0, err
+	}
+	return aggregated, rejected, nil
+}
+
+func (s *MemoryStore) StreamRange(ctx context.Context, query RangeQuery, rejected func(int), fn func(Measurement) error) error {
+	measurements, n, err := s.Range(ctx, query)
+	if err != nil {
+		return err
+	}
+	rejected(n)
+	for _, m := range measurements {
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Envelope(ctx context.Context, query RangeQuery) ([]EnvelopePoint, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	series, rejected := s.between(query)
+	points, err := envelopeFromSeries(query, series)
+	if err != nil {
+		return nil, 0, err
+	}
+	return points, rejected, nil
+}
+
+func (s *MemoryStore) Stats(ctx context.Context, query RangeQuery) (Stats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	series, _ := s.between(query)
+	return statsFromSeries(query, series)
+}
+
+func (s *MemoryStore) GroupRange(ctx context.Context, query RangeQuery, sensorIDs []string) ([]Measurement, int, error) {
+	return groupRangeBySensor(ctx, s, query, sensorIDs)
+}
+
+// envelopeFromSeries computes the envelope of the windows of query from series within its range.
+func envelopeFromSeries(query RangeQuery, series []Measurement) ([]EnvelopePoint, error) {
+	if len(series) == 0 {
+		return nil, ErrNoData
+	}
+
+	aggregates := []Aggregate{{Function: "min"}, AggregateMean, {Function: "max"}}
+	var windows [3][]Measurement
+	for i, agg := range aggregates {
+		aggregated, err := aggregateWindows(query.Field, query.SensorID, series, query.window(), agg)
+		if err != nil {
+			return nil, err
+		}
+		windows[i] = aggregated
+	}
+
+	points := make([]EnvelopePoint, len(windows[0]))
+	for i := range points {
+		min, _ := floatValue(windows[0][i])
+		mean, _ := floatValue(windows[1][i])
+		max, _ := floatValue(windows[2][i])
+		points[i] = EnvelopePoint{
+			SensorID: query.SensorID,
+			Field:    query.Field,
+			Min:      min,
+			Mean:     mean,
+			Max:      max,
+			Time:     windows[0][i].Time(),
+		}
+	}
+	return points, nil
+}
+
+// statsFromSeries computes the statistics of query from series within its range.
+func statsFromSeries(query RangeQuery, series []Measurement) (Stats, error) {
+	if len(series) == 0 {
+		return Stats{}, ErrNoData
+	}
+
+	stats := Stats{
+		SensorID: query.SensorID,
+		Field:    query.Field,
+		From:     query.Start,
+		To:       query.Stop,
+		First:    series[0],
+		Last:     series[len(series)-1],
+	}
+	var values []float64
+	var minValue, maxValue float64
+	for _, m := range series {
+		v, ok := floatValue(m)
+		if !ok {
+			continue
+		}
+		if stats.Min == nil || v < minValue {
+			stats.Min, minValue = m, v
+		}
+		if stats.Max == nil || v > maxValue {
+			stats.Max, maxValue = m, v
+		}
+		values = append(values, v)
+	}
+	stats.Count = len(values)
+	stats.Mean = mean(values)
+	stats.Median = quantile(values, 0.5)
+	stats.StdDev = stddev(values)
+	return stats, nil
+}
+
+// between returns measurements matching query within [Start, Stop),
+// leaving out measurements outside the bounds of the field unless query is raw,
+// and the number of measurements left out.
+// Caller must hold the lock.
+func (s *MemoryStore) between(query RangeQuery) ([]Measurement, int) {
+	bounds, filter := fieldBounds(query.Field)
+	filter = filter && !query.Raw
+
+	var (
+		inRange  []Measurement
+		rejected int
+	)
+	for _, m := range s.inTimeRange(query) {
+		if v, ok := floatValue(m); filter && ok && !bounds.contains(v) {
+			rejected++
+			continue
+		}
+		inRange = append(inRange, m)
+	}
+	return inRange, rejected
+}
+
+// inTimeRange returns measurements of the field and sensor of query within [Start, Stop).
+// Caller must hold the lock.
+func (s *MemoryStore) inTimeRange(query RangeQuery) []Measurement {
+	var inRange []Measurement
+	for _, m := range s.series(query.SensorID, query.Field) {
+		if m.Time().Before(query.Start) || !m.Time().Before(query.Stop) {
+			continue
+		}
+		inRange = append(inRange, m)
+	}
+	return inRange
+}
+
+// series returns the calibrated measurements of field from sensor id, computing derived fields.
+// Caller must hold the lock.
+func (s *MemoryStore) series(id, field string) []Measurement {
+	series := s.data[id][field]
+	if derived, ok := derivedFields[field]; ok {
+		inputs := make([][]Measurement, len(derived.inputs))
+		for i, input := range derived.inputs {
+			inputs[i] = calibrateAll(s.data[id][input.field])
+		}
+		series = derived.derive(field, id, inputs)
+	}
+	if field == movementsField {
+		series = movements(id, s.data[id][movementCounterField], s.data[id][sequenceNumberField])
+	}
+	return calibrateAll(series)
+}
+
+// aggregateWindows mimics Flux aggregateWindow(createEmpty: false),
+// stamping each window with its stop time.
+func aggregateWindows(
+	field, id string,
+	series []Measurement,
+	w window,
+	agg Aggregate,
+) ([]Measurement, error) {
+	var (
+		aggregated  []Measurement
+		windowStop  time.Time
+		values      []float64
+		flushWindow = func() error {
+			if len(values) == 0 {
+				return nil
+			}
+			m, err := NewMeasurement(field, id, agg.apply(values), windowStop)
+			if err != nil {
+				return err
+			}
+			aggregated = append(aggregated, m)
+			return nil
+		}
+	)
+
+	for _, m := range series {
+		v, ok := floatValue(m)
+		if !ok {
+			continue
+		}
+		_, stop := w.bounds(m.Time())
+		if !stop.Equal(windowStop) {
+			if err := flushWindow(); err != nil {
+				return nil, err
+			}
+			windowStop = stop
+			values = values[:0]
+		}
+		values = append(values, v)
+	}
+	if err := flushWindow(); err != nil {
+		return nil, err
+	}
+
+	return aggregated, nil
+}
+
+// windowStart returns the start of the epoch aligned window of length every containing t.
+func windowStart(t time.Time, every time.Duration) time.Time {
+	ns := t.UnixNano()
+	offset := ns % int64(every)
+	if offset < 0 {
+		offset += int64(every)
+	}
+	return time.Unix(0, ns-offset).UTC()
+}
+
+func (s *MemoryStore) Sensors(ctx context.Context) ([]SensorInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var sensors []SensorInfo
+	for id, fields := range s.data {
+		info := SensorInfo{ID: id}
+		for field, series := range fields {
+			if len(series) == 0 {
+				continue
+			}
+			info.Fields = append(info.Fields, field)
+			first, last := series[0].Time(), series[len(series)-1].Time()
+			if info.FirstSeen.IsZero() || first.Before(info.FirstSeen) {
+				info.FirstSeen = first
+			}
+			if last.After(info.LastSeen) {
+				info.LastSeen = last
+			}
+		}
+		sort.Strings(info.Fields)
+		sensors = append(sensors, info)
+	}
+	sort.Slice(sensors, func(i, j int) bool {
+		return sensors[i].ID < sensors[j].ID
+	})
+	return sensors, nil
+}
+
+func (s *MemoryStore) Fields(ctx context.Context, id string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	fields, ok := s.data[id]
+	if !ok {
+		return nil, ErrNoData
+	}
+	var names []string
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return withDerivedFields(names), nil
+}
+
+func (s *MemoryStore) Snapshot(ctx context.Context) ([]Snapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var snapshots []Snapshot
+	for id, fields := range s.data {
+		snapshot := Snapshot{
+			SensorID:     id,
+			Measurements: make(map[string]Measurement),
+		}
+		for _, field := range SnapshotFields {
+			if series := fields[field]; len(series) > 0 {
+				snapshot.Measurements[field] = calibrate(series[len(series)-1], "")
+			}
+		}
+		if len(snapshot.Measurements) > 0 {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	if len(snapshots) == 0 {
+		return nil, ErrNoData
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].SensorID < snapshots[j].SensorID
+	})
+	return snapshots, nil
+}
+
+func (s *MemoryStore) Activity(ctx context.Context, window time.Duration) ([]SensorActivity, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	since := time.Now().Add(-window)
+	var activity []SensorActivity
+	for id, fields := range s.data {
+		reported := make(map[time.Time]bool)
+		for _, series := range fields {
+			for _, m := range series {
+				if !m.Time().Before(since) {
+					reported[m.Time().UTC()] = true
+				}
+			}
+		}
+		if len(reported) == 0 {
+			continue
+		}
+		a := SensorActivity{ID: id}
+		for t := range reported {
+			a.Reports = append(a.Reports, t)
+		}
+		sort.Slice(a.Reports, func(i, j int) bool {
+			return a.Reports[i].Before(a.Reports[j])
+		})
+		activity = append(activity, a)
+	}
+	sort.Slice(activity, func(i, j int) bool {
+		return activity[i].ID < activity[j].ID
+	})
+	return activity, nil
+}
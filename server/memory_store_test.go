@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// addSeries adds values of field from sensor id to store, one every step from start.
+func addSeries(t *testing.T, store *MemoryStore, field, id string, values []float64, start time.Time, step time.Duration) {
+	t.Helper()
+	for i, v := range values {
+		m, err := NewMeasurement(field, id, v, start.Add(time.Duration(i)*step))
+		if err != nil {
+			t.Fatal(err)
+		}
+		store.Add(m)
+	}
+}
+
+func temperatureStore(t *testing.T, start time.Time, values ...float64) *MemoryStore {
+	t.Helper()
+	store := NewMemoryStore()
+	addSeries(t, store, "temperature", "sensor", values, start, time.Minute)
+	return store
+}
+
+func TestRangeCountsRejected(t *testing.T) {
+	start := time.Date(2022, 10, 30, 0, 0, 0, 0, time.UTC)
+	store := temperatureStore(t, start, 20, 150, 21, -80, 22)
+	query := RangeQuery{Field: "temperature", SensorID: "sensor", Start: start, Stop: start.Add(time.Hour)}
+
+	measurements, rejected, err := store.Range(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(measurements) != 3 || rejected != 2 {
+		t.Errorf("got %d measurements and %d rejected, want 3 and 2", len(measurements), rejected)
+	}
+
+	query.Raw = true
+	measurements, rejected, err = store.Range(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(measurements) != 5 || rejected != 0 {
+		t.Errorf("raw: got %d measurements and %d rejected, want 5 and 0", len(measurements), rejected)
+	}
+}
+
+func TestStreamRangeReportsRejectedFirst(t *testing.T) {
+	start := time.Date(2022, 10, 30, 0, 0, 0, 0, time.UTC)
+	store := withVirtualFields(withDespiking(temperatureStore(t, start, 20, 150, 21, 22)))
+	query := RangeQuery{Field: "temperature", SensorID: "sensor", Start: start, Stop: start.Add(time.Hour)}
+
+	rejected := -1
+	err := store.StreamRange(context.Background(), query, func(n int) {
+		if rejected >= 0 {
+			t.Error("rejected count was passed more than once")
+		}
+		rejected = n
+	}, func(m Measurement) error {
+		if rejected < 0 {
+			t.Error("measurement was passed before the rejected count")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rejected != 1 {
+		t.Errorf("rejected = %d, want 1", rejected)
+	}
+}
+
+func TestDespikedRangeCountsSpikesAndOutOfBounds(t *testing.T) {
+	start := time.Date(2022, 10, 30, 0, 0, 0, 0, time.UTC)
+	values := []float64{20, 20.1, 20, 20.2, 20.1, 35, 20, 20.1, 150, 20.2, 20, 20.1}
+	store := withDespiking(temperatureStore(t, start, values...))
+	query := RangeQuery{Field: "temperature", SensorID: "sensor", Start: start, Stop: start.Add(time.Hour), Despike: true}
+
+	measurements, rejected, err := store.Range(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rejected != 2 || len(measurements) != len(values)-2 {
+		t.Errorf("got %d measurements and %d rejected, want %d and 2", len(measurements), rejected, len(values)-2)
+	}
+}
+
+func TestGroupRangeCountsRejected(t *testing.T) {
+	start := time.Date(2022, 10, 30, 0, 0, 0, 0, time.UTC)
+	store := temperatureStore(t, start, 20, 150, 21)
+	addSeries(t, store, "temperature", "other", []float64{-80, 22, -90}, start, time.Minute)
+	query := RangeQuery{Field: "temperature", Start: start, Stop: start.Add(time.Hour), Interval: time.Hour, Aggregate: AggregateMean}
+
+	measurements, rejected, err := withVirtualFields(withDespiking(store)).GroupRange(context.Background(), query, []string{"sensor", "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(measurements) != 2 || rejected != 3 {
+		t.Errorf("got %d measurements and %d rejected, want 2 and 3", len(measurements), rejected)
+	}
+}
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// mouldIndexField is the virtual field holding the mould index
+	mouldIndexField = "mouldindex"
+
+	// mouldStep is the resolution of the temperature and humidity history fed to the mould model
+	mouldStep = time.Hour
+
+	// mouldLookback is how much history the latest mould index is computed from
+	mouldLookback = 90 * 24 * time.Hour
+
+	// maxMouldIndex is the mould index of heavy growth covering the whole surface
+	maxMouldIndex = 6
+)
+
+// MouldSensitivity holds the material parameters of the VTT mould growth model.
+type MouldSensitivity struct {
+	// k1 coefficients before and after visible growth begins at index 1
+	k1Initial, k1Visible float64
+
+	// a, b and c describe the maximum index reachable in given conditions
+	a, b, c float64
+
+	// rhMin is the lowest relative humidity allowing growth, in percent
+	rhMin float64
+
+	// decline is the relative rate at which the index declines in unfavourable conditions
+	decline float64
+}
+
+// mouldSensitivities are the sensitivity classes of the updated VTT model by Ojanen et al. (2010).
+var mouldSensitivities = map[string]MouldSensitivity{
+	"verysensitive": {
+		k1Initial: 1, k1Visible: 2,
+		a: 1, b: 7, c: 2,
+		rhMin:   80,
+		decline: 1,
+	},
+	"sensitive": {
+		k1Initial: 0.578, k1Visible: 0.386,
+		a: 0.3, b: 6, c: 1,
+		rhMin:   80,
+		decline: 0.5,
+	},
+	"mediumresistant": {
+		k1Initial: 0.072, k1Visible: 0.097,
+		a: 0, b: 5, c: 1.5,
+		rhMin:   85,
+		decline: 0.25,
+	},
+	"resistant": {
+		k1Initial: 0.033, k1Visible: 0.014,
+		a: 0, b: 3, c: 1,
+		rhMin:   85,
+		decline: 0.1,
+	},
+}
+
+// defaultMouldSensitivity is used when none is given, as untreated pine sapwood
+// found in crawlspaces and roof structures is very sensitive.
+const defaultMouldSensitivity = "verysensitive"
+
+// ParseMouldSensitivity returns the sensitivity class called name, or the default if name is empty.
+func ParseMouldSensitivity(name string) (MouldSensitivity, error) {
+	if name == "" {
+		name = defaultMouldSensitivity
+	}
+	sensitivity, ok := mouldSensitivities[name]
+	if !ok {
+		return MouldSensitivity{}, errors.New("unknown mould sensitivity: " + name)
+	}
+	return sensitivity, nil
+}
+
+// MouldIndex is the mould growth index of a sensor over time.
+// The index has no unit, and the model is always fed metric temperature and humidity,
+// so it is unaffected by the units of a request.
+type MouldIndex struct {
+	SensorID    string        `json:"sensorID"`
+	Sensitivity string        `json:"sensitivity"`
+	Current     Measurement   `json:"current"`
+	Max         Measurement   `json:"max"`
+	Index       []Measurement `json:"index"`
+}
+
+// mouldModel steps the VTT mould growth model of Hukka & Viitanen (1999),
+// updated by Ojanen et al. (2010), through a temperature and humidity history.
+// The index ranges from 0, no growth, to 6, heavy growth covering the whole surface.
+type mouldModel struct {
+	sensitivity MouldSensitivity
+
+	index float64
+
+	// unfavourable is how long conditions have not allowed growth
+	unfavourable time.Duration
+}
+
+// criticalHumidity returns the relative humidity in percent above which mould grows at temperature t.
+func (m *mouldModel) criticalHumidity(t float64) float64 {
+	if t > 20 {
+		return m.sensitivity.rhMin
+	}
+	return math.Max(-0.00267*t*t*t+0.160*t*t-3.13*t+100, m.sensitivity.rhMin)
+}
+
+// step advances the model by dt spent in temperature t in Celsius and relative humidity rh in percent.
+func (m *mouldModel) step(t, rh float64, dt time.Duration) {
+	hours := dt.Hours()
+	rhCrit := m.criticalHumidity(t)
+
+	if t <= 0 || t >= 50 || rh < rhCrit {
+		// the index declines after six hours of unfavourable conditions,
+		// first faster and then slower after a day
+		switch {
+		case m.unfavourable < 6*time.Hour:
+			m.index -= m.sensitivity.decline * 0.00133 * hours
+		case m.unfavourable >= 24*time.Hour:
+			m.index -= m.sensitivity.decline * 0.000667 * hours
+		}
+		m.unfavourable += dt
+		m.index = math.Max(m.index, 0)
+		return
+	}
+	m.unfavourable = 0
+
+	k1 := m.sensitivity.k1Initial
+	if m.index >= 1 {
+		k1 = m.sensitivity.k1Visible
+	}
+	ratio := (rhCrit - rh) / (rhCrit - 100)
+	maxIndex := m.sensitivity.a + m.sensitivity.b*ratio - m.sensitivity.c*ratio*ratio
+	k2 := math.Max(1-math.Exp(2.3*(m.index-maxIndex)), 0)
+
+	// weeks to growth for sawn pine, W = 0 and SQ = 0
+	weeks := math.Exp(-0.68*math.Log(t) - 13.9*math.Log(rh) + 66.02)
+	m.index += hours / (7 * 24 * weeks) * k1 * k2
+	m.index = math.Min(m.index, maxMouldIndex)
+}
+
+// mouldIndexSeries computes the mould index of sensor id after each of its hourly
+// temperature and humidity means matching query. The index starts from zero at
+// the beginning of the range, so the range should start from dry conditions.
+// Gaps in the history are skipped, as if time had stopped.
+// The number of temperature and humidity values left out like in Store.Range is returned too.
+func mouldIndexSeries(ctx context.Context, store Store, query RangeQuery, sensitivity MouldSensitivity) ([]Measurement, int, error) {
+	query.Interval = mouldStep
+	query.Months = 0
+	query.Aggregate = AggregateMean
+
+	temperatureQuery := query
+	temperatureQuery.Field = "temperature"
+	temperature, temperatureRejected, err := store.Range(ctx, temperatureQuery)
+	if err != nil {
+		return nil, 0, err
+	}
+	humidityQuery := query
+	humidityQuery.Field = "humidity"
+	humidity, humidityRejected, err := store.Range(ctx, humidityQuery)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	humidityByTime := make(map[time.Time]float64, len(humidity))
+	for _, m := range humidity {
+		if v, ok := floatValue(m); ok {
+			humidityByTime[m.Time().UTC()] = v
+		}
+	}
+	sort.SliceStable(temperature, func(i, j int) bool {
+		return temperature[i].Time().Before(temperature[j].Time())
+	})
+
+	model := mouldModel{sensitivity: sensitivity}
+	var index []Measurement
+	for _, m := range temperature {
+		t, ok := floatValue(m)
+		if !ok {
+			continue
+		}
+		rh, ok := humidityByTime[m.Time().UTC()]
+		if !ok {
+			continue
+		}
+		model.step(t, rh, mouldStep)
+		measurement, err := NewMeasurement(mouldIndexField, query.SensorID, model.index, m.Time())
+		if err != nil {
+			return nil, 0, err
+		}
+		index = append(index, measurement)
+	}
+
+	if len(index) == 0 {
+		return nil, 0, ErrNoData
+	}
+	return index, temperatureRejected + humidityRejected, nil
+}
+
+// computeMouldIndex computes the mould index of the sensor of query over its range
+// for the material of the given sensitivity class, reported by its name.
+func computeMouldIndex(ctx context.Context, store Store, query RangeQuery, sensitivity MouldSensitivity, sensitivityName string) (MouldIndex, error) {
+	index, _, err := mouldIndexSeries(ctx, store, query, sensitivity)
+	if err != nil {
+		return MouldIndex{}, err
+	}
+
+	result := MouldIndex{
+		SensorID:    query.SensorID,
+		Sensitivity: sensitivityName,
+		Current:     index[len(index)-1],
+		Index:       index,
+	}
+	var maxValue float64
+	for _, m := range index {
+		if v, _ := floatValue(m); result.Max == nil || v > maxValue {
+			result.Max, maxValue = m, v
+		}
+	}
+	return result, nil
+}
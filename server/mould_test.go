@@ -0,0 +1,94 @@
+package server
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// mouldSensitivityTests are the sensitivity classes with their maximum index in constant 100% humidity,
+// a + b - c, as published by Ojanen et al. (2010).
+var mouldSensitivityTests = []struct {
+	name     string
+	maxIndex float64
+}{
+	{"verysensitive", 6},
+	{"sensitive", 5.3},
+	{"mediumresistant", 3.5},
+	{"resistant", 2},
+}
+
+func mouldModelOf(t *testing.T, name string, index float64) mouldModel {
+	t.Helper()
+	sensitivity, err := ParseMouldSensitivity(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mouldModel{sensitivity: sensitivity, index: index}
+}
+
+func TestMouldModelReachesMaximumIndex(t *testing.T) {
+	for _, tt := range mouldSensitivityTests {
+		model := mouldModelOf(t, tt.name, 0)
+		var previous float64
+		for i := 0; i < 20*365*24; i++ {
+			model.step(20, 100, time.Hour)
+			if model.index < previous || model.index > tt.maxIndex {
+				t.Fatalf("%s: index went from %v to %v after %d hours, want growth up to %v",
+					tt.name, previous, model.index, i+1, tt.maxIndex)
+			}
+			previous = model.index
+		}
+		if math.Abs(model.index-tt.maxIndex) > 0.05 {
+			t.Errorf("%s: index %v after 20 years of favourable conditions, want %v", tt.name, model.index, tt.maxIndex)
+		}
+	}
+}
+
+func TestMouldModelDeclines(t *testing.T) {
+	for _, tt := range mouldSensitivityTests {
+		decline := mouldSensitivities[tt.name].decline
+		model := mouldModelOf(t, tt.name, 1)
+		// the index declines during the first six hours and after a day of unfavourable conditions
+		want := map[int]float64{
+			6:  1 - 6*decline*0.00133,
+			24: 1 - 6*decline*0.00133,
+			48: 1 - 6*decline*0.00133 - 24*decline*0.000667,
+		}
+		for hours := 1; hours <= 48; hours++ {
+			model.step(20, 50, time.Hour)
+			if w, ok := want[hours]; ok && math.Abs(model.index-w) > 1e-9 {
+				t.Errorf("%s: index %v after %d unfavourable hours, want %v", tt.name, model.index, hours, w)
+			}
+		}
+
+		// favourable conditions restart the decline from the faster rate
+		model.step(20, 100, time.Hour)
+		before := model.index
+		model.step(20, 50, time.Hour)
+		if w := before - decline*0.00133; math.Abs(model.index-w) > 1e-9 {
+			t.Errorf("%s: index %v after growth and an unfavourable hour, want %v", tt.name, model.index, w)
+		}
+	}
+}
+
+func TestMouldModelClamps(t *testing.T) {
+	for _, tt := range mouldSensitivityTests {
+		model := mouldModelOf(t, tt.name, 0.0001)
+		model.step(20, 50, time.Hour)
+		if model.index != 0 {
+			t.Errorf("%s: index %v after declining below zero, want 0", tt.name, model.index)
+		}
+		model.step(0, 100, time.Hour)
+		model.step(50, 100, time.Hour)
+		if model.index != 0 {
+			t.Errorf("%s: index %v after freezing and heat, want 0", tt.name, model.index)
+		}
+
+		model = mouldModelOf(t, tt.name, maxMouldIndex+0.5)
+		model.step(20, 100, time.Hour)
+		if model.index != maxMouldIndex {
+			t.Errorf("%s: index %v after growing above the maximum, want %v", tt.name, model.index, maxMouldIndex)
+		}
+	}
+}
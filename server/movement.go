@@ -0,0 +1,132 @@
+package server
+
+import (
+	"github.com/LassiHeikkila/mokki-cloud/server/flux"
+)
+
+const (
+	movementCounterField = "movementCounter"
+	movementsField       = "movements"
+	sequenceNumberField  = "measurementSequenceNumber"
+
+	// movementCounterModulus is where the movement counter of RuuviTag data format 5 wraps around:
+	// it counts from 0 to 254, 255 being reserved for an unavailable value
+	movementCounterModulus = 255
+)
+
+// movementIncrement returns the movements detected by a sensor between two of its reports,
+// given the increments of its movement counter and measurement sequence number since the previous report
+// and the current counter value.
+// When the counter decreases, it has either wrapped around or been reset by the sensor restarting.
+// A restart resets the sequence number too, so a decrease along with the sequence number
+// counts the counter value as the movements since the restart, and any other decrease is a wrap around.
+func movementIncrement(counterStep, sequenceStep, counter float64) float64 {
+	switch {
+	case counterStep >= 0:
+		return counterStep
+	case sequenceStep > 0:
+		return counterStep + movementCounterModulus
+	}
+	return counter
+}
+
+// filterMovements narrows query, already limited to one sensor, to the movement counter and sequence numbers,
+// and replaces them with the movements since the previous report, e.g. openings of the door the sensor is mounted on.
+// Each report after the first one in the range gives the number of movements since the previous report,
+// so the sum over an interval is the number of movements during it.
+// The result looks like records of a field called movements.
+func filterMovements(query *flux.Query) *flux.Query {
+	return movementsFromInputs(filterMovementInputs(query))
+}
+
+// movementInputs are the fields movements are computed from.
+var movementInputs = []string{movementCounterField, sequenceNumberField}
+
+// filterMovementInputs narrows query to the fields movements are computed from,
+// leaving out values outside the bounds of each, such as the unavailable values 255 and 65535.
+func filterMovementInputs(query *flux.Query) *flux.Query {
+	query = query.FilterIn("_field", movementInputs)
+	for _, field := range movementInputs {
+		if bounds, ok := fieldBounds(field); ok {
+			query = query.Add(
+				`|> filter(fn: (r) => r._field != ? or (float(v: r._value) >= ? and float(v: r._value) <= ?))`,
+				field, bounds.Min, bounds.Max,
+			)
+		}
+	}
+	return query
+}
+
+// movementInput returns the value of m, a measurement of one of movementInputs,
+// and whether it is within the bounds of its field.
+func movementInput(m Measurement) (float64, bool) {
+	v, ok := floatValue(m)
+	if !ok {
+		return 0, false
+	}
+	if bounds, ok := fieldBounds(m.Measurement()); ok && !bounds.contains(v) {
+		return 0, false
+	}
+	return v, true
+}
+
+// movementsFromInputs replaces the counter and sequence number records of consecutive reports
+// with the movements between them, see movementIncrement.
+func movementsFromInputs(query *flux.Query) *flux.Query {
+	return query.
+		Add(`|> pivot(rowKey: ["_time"], columnKey: ["_field"], valueColumn: "_value")`).
+		Add(`|> filter(fn: (r) => exists r.movementCounter and exists r.measurementSequenceNumber)`).
+		Add(`|> sort(columns: ["_time"])`).
+		Add(`|> map(fn: (r) => ({r with
+counter: int(v: r.movementCounter),
+counterStep: int(v: r.movementCounter),
+sequenceStep: int(v: r.measurementSequenceNumber)
+}))`).
+		Add(`|> difference(columns: ["counterStep", "sequenceStep"])`).
+		Add(`|> map(fn: (r) => ({r with
+_field: ?,
+_value: float(v: if r.counterStep >= 0 then r.counterStep
+	else if r.sequenceStep > 0 then r.counterStep + ?
+	else r.counter)
+}))`, movementsField, movementCounterModulus).
+		Add(`|> drop(columns: [?, ?, "counter", "counterStep", "sequenceStep"])`, movementCounterField, sequenceNumberField)
+}
+
+// movements computes the movements of sensor id from its counter and sequence number series
+// like filterMovements, joining measurements of equal time. Both series must be sorted by time.
+func movements(id string, counter, sequence []Measurement) []Measurement {
+	sequenceAt := make(map[int64]float64, len(sequence))
+	for _, m := range sequence {
+		if v, ok := movementInput(m); ok {
+			sequenceAt[m.Time().UnixNano()] = v
+		}
+	}
+
+	var (
+		result                            []Measurement
+		previousCounter, previousSequence float64
+		first                             = true
+	)
+	for _, m := range counter {
+		v, ok := movementInput(m)
+		if !ok {
+			continue
+		}
+		number, ok := sequenceAt[m.Time().UnixNano()]
+		if !ok {
+			continue
+		}
+		if first {
+			previousCounter, previousSequence, first = v, number, false
+			continue
+		}
+		increment := movementIncrement(v-previousCounter, number-previousSequence, v)
+		previousCounter, previousSequence = v, number
+		movement, err := NewMeasurement(movementsField, id, increment, m.Time())
+		if err != nil {
+			continue
+		}
+		result = append(result, movement)
+	}
+	return result
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LassiHeikkila/mokki-cloud/server/flux"
+)
+
+func TestMovementsHandleWrapAroundAndRestart(t *testing.T) {
+	start := time.Date(2022, 10, 30, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	reports := []struct {
+		counter, sequence float64
+		want              float64
+	}{
+		{250, 100, 0},
+		// wrap around
+		{3, 110, 8},
+		{5, 120, 2},
+		// restart resetting both
+		{2, 4, 2},
+		{2, 10, 0},
+		// restart with the sequence number barely reset
+		{1, 3, 1},
+	}
+	var counters, sequences []float64
+	for _, r := range reports {
+		counters = append(counters, r.counter)
+		sequences = append(sequences, r.sequence)
+	}
+	addSeries(t, store, movementCounterField, "sensor", counters, start, time.Minute)
+	addSeries(t, store, sequenceNumberField, "sensor", sequences, start, time.Minute)
+	query := RangeQuery{Field: movementsField, SensorID: "sensor", Start: start, Stop: start.Add(time.Hour)}
+
+	measurements, _, err := store.Range(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(measurements) != len(reports)-1 {
+		t.Fatalf("got %d movements, want %d", len(measurements), len(reports)-1)
+	}
+	for i, m := range measurements {
+		if v, _ := floatValue(m); v != reports[i+1].want {
+			t.Errorf("movements at %s = %v, want %v", m.Time(), v, reports[i+1].want)
+		}
+	}
+
+	query.Interval = time.Hour
+	query.Aggregate, err = fieldAggregate(movementsField, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	measurements, _, err = store.Range(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(measurements) != 1 {
+		t.Fatalf("got %d windows, want 1", len(measurements))
+	}
+	if v, _ := floatValue(measurements[0]); v != 13 {
+		t.Errorf("sum of movements = %v, want 13", v)
+	}
+}
+
+func TestMovementsLeaveOutUnavailableValues(t *testing.T) {
+	start := time.Date(2022, 10, 30, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	// 255 and 65535 are the unavailable values of the counter and the sequence number
+	addSeries(t, store, movementCounterField, "sensor", []float64{10, 255, 12, 13, 3}, start, time.Minute)
+	addSeries(t, store, sequenceNumberField, "sensor", []float64{100, 110, 120, 65535, 140}, start, time.Minute)
+	query := RangeQuery{Field: movementsField, SensorID: "sensor", Start: start, Stop: start.Add(time.Hour)}
+
+	measurements, _, err := store.Range(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a wrap around from 12 to 3, not a restart seen from 65535 to 140
+	want := []float64{2, 246}
+	if len(measurements) != len(want) {
+		t.Fatalf("got %d movements, want %d", len(measurements), len(want))
+	}
+	for i, m := range measurements {
+		if v, _ := floatValue(m); v != want[i] {
+			t.Errorf("movements at %s = %v, want %v", m.Time(), v, want[i])
+		}
+	}
+}
+
+func TestMovementsQuery(t *testing.T) {
+	query := filterField(flux.From("bucket"), movementsField, nil)
+	if err := query.Err(); err != nil {
+		t.Fatal(err)
+	}
+	text := query.String()
+	for _, want := range []string{
+		`r._field != "movementCounter" or (float(v: r._value) >= 0.0 and float(v: r._value) <= 254.0)`,
+		`r._field != "measurementSequenceNumber" or (float(v: r._value) >= 0.0 and float(v: r._value) <= 65534.0)`,
+		`difference(columns: ["counterStep", "sequenceStep"])`,
+		`r.counterStep + 255`,
+		`_field: "movements"`,
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("query lacks %s:\n%s", want, text)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"math"
+	"sort"
+)
+
+// Bounds are the plausible values of a field, inclusive, see FieldType.
+type Bounds struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+func (b Bounds) contains(v float64) bool {
+	return v >= b.Min && v <= b.Max
+}
+
+const (
+	// despikeRadius is the number of neighbours on each side a measurement is compared to
+	despikeRadius = 3
+
+	// despikeThreshold is how many scaled median absolute deviations
+	// a measurement may differ from the median of its neighbourhood
+	despikeThreshold = 3.5
+
+	// madScale makes the median absolute deviation comparable to standard deviation
+	madScale = 1.4826
+)
+
+// despike rejects spikes from series using a Hampel filter: a measurement is a spike
+// if it differs from the median of its neighbourhood by more than despikeThreshold
+// scaled median absolute deviations. Neighbourhoods without any deviation keep all measurements.
+// Returns the measurements kept and the number rejected.
+func despike(series []Measurement) ([]Measurement, int) {
+	values := make([]float64, len(series))
+	numeric := make([]bool, len(series))
+	for i, m := range series {
+		values[i], numeric[i] = floatValue(m)
+	}
+
+	kept := make([]Measurement, 0, len(series))
+	rejected := 0
+	neighbourhood := make([]float64, 0, 2*despikeRadius+1)
+	deviations := make([]float64, 0, 2*despikeRadius+1)
+	for i, m := range series {
+		if !numeric[i] {
+			kept = append(kept, m)
+			continue
+		}
+
+		neighbourhood = neighbourhood[:0]
+		for j := i - despikeRadius; j <= i+despikeRadius; j++ {
+			if j >= 0 && j < len(series) && numeric[j] {
+				neighbourhood = append(neighbourhood, values[j])
+			}
+		}
+		median := quantile(neighbourhood, 0.5)
+		deviations = deviations[:0]
+		for _, v := range neighbourhood {
+			deviations = append(deviations, math.Abs(v-median))
+		}
+		sort.Float64s(deviations)
+		mad := madScale * quantile(deviations, 0.5)
+
+		if mad > 0 && math.Abs(values[i]-median) > despikeThreshold*mad {
+			rejected++
+			continue
+		}
+		kept = append(kept, m)
+	}
+	return kept, rejected
+}
+
+// despikingStore is a Store applying the spike filter to range queries with Despike set.
+// Spikes can only be told apart in raw data, so such queries are aggregated in the server.
+type despikingStore struct {
+	Store
+}
+
+func withDespiking(store Store) Store {
+	if _, ok := store.(despikingStore); ok {
+		return store
+	}
+	return despikingStore{Store: store}
+}
+
+// despiked returns the raw measurements of query with spikes removed,
+// and the number of measurements left out as implausible or as spikes.
+func (s despikingStore) despiked(ctx context.Context, query RangeQuery) ([]Measurement, int, error) {
+	query.Interval = 0
+	query.Months = 0
+	query.Despike = false
+	series, outOfBounds, err := s.Store.Range(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+	kept, spikes := despike(series)
+	if len(kept) == 0 {
+		return nil, 0, ErrNoData
+	}
+	return kept, outOfBounds + spikes, nil
+}
+
+func (s despikingStore) Range(ctx context.Context, query RangeQuery) ([]Measurement, int, error) {
+	if !query.Despike {
+		return s.Store.Range(ctx, query)
+	}
+	series, rejected, err := s.despiked(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+	if query.Interval <= 0 && query.Months <= 0 {
+		return series, rejected, nil
+	}
+	aggregated, err := aggregateWindows(query.Field, query.SensorID, series, query.window(), query.Aggregate)
+	if err != nil {
+		return nil, 0, err
+	}
+	return aggregated, rejected, nil
+}
+
+func (s despikingStore) StreamRange(ctx context.Context, query RangeQuery, rejected func(int), fn func(Measurement) error) error {
+	if !query.Despike {
+		return s.Store.StreamRange(ctx, query, rejected, fn)
+	}
+	measurements, n, err := s.Range(ctx, query)
+	if err != nil {
+		return err
+	}
+	rejected(n)
+	for _, m := range measurements {
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s despikingStore) Envelope(ctx context.Context, query RangeQuery) ([]EnvelopePoint, int, error) {
+	if !query.Despike {
+		return s.Store.Envelope(ctx, query)
+	}
+	series, rejected, err := s.despiked(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+	points, err := envelopeFromSeries(query, series)
+	if err != nil {
+		return nil, 0, err
+	}
+	return points, rejected, nil
+}
+
+func (s despikingStore) Stats(ctx context.Context, query RangeQuery) (Stats, error) {
+	if !query.Despike {
+		return s.Store.Stats(ctx, query)
+	}
+	series, _, err := s.despiked(ctx, query)
+	if err != nil {
+		return Stats{}, err
+	}
+	return statsFromSeries(query, series)
+}
+
+func (s despikingStore) GroupRange(ctx context.Context, query RangeQuery, sensorIDs []string) ([]Measurement, int, error) {
+	if !query.Despike {
+		return s.Store.GroupRange(ctx, query, sensorIDs)
+	}
+	return groupRangeBySensor(ctx, s, query, sensorIDs)
+}
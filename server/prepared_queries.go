@@ -2,142 +2,633 @@ package server
 
 import (
 	"context"
-	"fmt"
 	"log"
+	"sort"
 	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/query"
+
+	"github.com/LassiHeikkila/mokki-cloud/server/calibration"
+	"github.com/LassiHeikkila/mokki-cloud/server/flux"
 )
 
-const (
-	fromBucket = `from(bucket: "%s")`
+const discoveryLookback = 365 * 24 * time.Hour
 
-	queryLastRecord   = `|> last()`
-	queryLastDuration = `|> range(start: -%v)`
-	queryBetweenTimes = `|> range(start: %s, stop: %s)`
+// filterSensor narrows query to sensorID within the configured measurement.
+func (q *Querier) filterSensor(query *flux.Query, sensorID string) *flux.Query {
+	return query.
+		FilterEquals("sensormac", sensorID).
+		FilterEquals("_measurement", q.measurement)
+}
 
-	filterSensorMAC   = `|> filter(fn: (r) => r["sensormac"] == "%s")`
-	filterMeasurement = `|> filter(fn: (r) => r["_measurement"] == "%s")`
+// filterSensorField narrows query to field of sensorID within the configured measurement.
+func (q *Querier) filterSensorField(query *flux.Query, field, sensorID string) *flux.Query {
+	return filterField(q.filterSensor(query, sensorID), field, []string{sensorID})
+}
 
-	filterField = `|> filter(fn: (r) => r["_field"] == "%s")`
+// filterField narrows query, already limited to sensorIDs, to field.
+// Derived fields and movements are computed from the records of their inputs.
+func filterField(query *flux.Query, field string, sensorIDs []string) *flux.Query {
+	if derived, ok := derivedFields[field]; ok {
+		return derived.filter(query, field, sensorIDs)
+	}
+	if field == movementsField {
+		return filterMovements(query)
+	}
+	return query.FilterEquals("_field", field)
+}
 
-	aggregate = `|> aggregateWindow(every: %s, fn: mean, createEmpty: false) |> yield(name: "mean")`
-)
+// filterRangeQuery narrows query to the field and sensor of rq,
+// leaving out values outside the bounds of the field unless rq is raw.
+func (q *Querier) filterRangeQuery(query *flux.Query, rq RangeQuery) *flux.Query {
+	return filterBounds(q.filterSensorField(query, rq.Field, rq.SensorID), rq)
+}
 
-// QueryLastValue assumes there is some data in the past 3h
-func (q *Querier) QueryLastValue(ctx context.Context, bucket, field, sensorID, measurement string) Measurement {
-	query := fmt.Sprintf(fromBucket, bucket)
-	query += fmt.Sprintf(queryLastDuration, 24*time.Hour)
-	query += fmt.Sprintf(filterSensorMAC, sensorID)
-	query += fmt.Sprintf(filterMeasurement, measurement)
-	query += fmt.Sprintf(filterField, field)
-	query += queryLastRecord
+// filterBounds leaves out values outside the bounds of the field of rq unless rq is raw.
+func filterBounds(query *flux.Query, rq RangeQuery) *flux.Query {
+	if bounds, ok := fieldBounds(rq.Field); ok && !rq.Raw {
+		query = query.Add(`|> filter(fn: (r) => float(v: r._value) >= ? and float(v: r._value) <= ?)`, bounds.Min, bounds.Max)
+	}
+	return query
+}
 
-	log.Println("running query:", query)
+// QueryLastValue gets the last value of field reported by sensorID during the past year,
+// so that the last value of a sensor which has gone quiet is found too.
+// Derived fields are computed from the last values of their inputs only.
+func (q *Querier) QueryLastValue(ctx context.Context, field, sensorID string) (Measurement, error) {
+	query := q.filterSensor(flux.From(q.bucket).RangeSince(discoveryLookback), sensorID)
+	if derived, ok := derivedFields[field]; ok {
+		query = derived.fromInputs(derived.filterInputs(query).Last(), field, []string{sensorID})
+	} else if field == movementsField {
+		// the movements of the latest report are computed from the two latest reports
+		query = movementsFromInputs(filterMovementInputs(query).Add(`|> tail(n: 2)`))
+	} else {
+		query = query.FilterEquals("_field", field).Last()
+	}
 
 	records, err := q.ExecuteQuery(ctx, query)
 	if err != nil {
-		log.Println("error running query:", err)
-		return nil
+		return nil, err
 	}
 
-	if len(records) < 1 {
-		log.Println("no records found")
-		return nil
-	}
 	// since we only want the last record, assume there is at most one record returned
 	for _, r := range records {
 		m, err := MeasurementFromRecord(r)
 		if err != nil {
-			log.Printf("hep: %e", err)
+			log.Println("error converting record:", err)
 			continue
 		}
 		if m.Measurement() == field {
-			return m
+			return m, nil
 		}
 	}
-	log.Printf("no %s measurement found\n", field)
-	return nil
+	return nil, ErrNoData
 }
 
-func (q *Querier) QueryLastDuration(
+// QueryLastDuration gets measurements of the past rq.Since, relative to the database's clock,
+// and the number of values left out as implausible.
+func (q *Querier) QueryLastDuration(ctx context.Context, rq RangeQuery) ([]Measurement, int, error) {
+	return collectMeasurements(func(rejected func(int), fn func(Measurement) error) error {
+		return q.StreamLastDuration(ctx, rq, rejected, fn)
+	})
+}
+
+func (q *Querier) QueryBetweenTimes(ctx context.Context, rq RangeQuery) ([]Measurement, int, error) {
+	return collectMeasurements(func(rejected func(int), fn func(Measurement) error) error {
+		return q.StreamBetweenTimes(ctx, rq, rejected, fn)
+	})
+}
+
+// StreamLastDuration is the streaming version of QueryLastDuration.
+func (q *Querier) StreamLastDuration(ctx context.Context, rq RangeQuery, rejected func(int), fn func(Measurement) error) error {
+	return q.streamRangeQuery(ctx, flux.From(q.bucket).RangeSince(rq.Since), rq, rejected, fn)
+}
+
+// StreamBetweenTimes is the streaming version of QueryBetweenTimes.
+func (q *Querier) StreamBetweenTimes(ctx context.Context, rq RangeQuery, rejected func(int), fn func(Measurement) error) error {
+	return q.streamRangeQuery(ctx, flux.From(q.bucket).Range(rq.Start, rq.Stop), rq, rejected, fn)
+}
+
+// streamRangeQuery completes fluxQuery according to rq, converts records to measurements
+// as they arrive and passes them to fn.
+// Values outside the bounds of the field are counted with a separate query first,
+// and the count is passed to rejected before streaming starts.
+// ErrNoData is returned if no measurements were passed to fn.
+func (q *Querier) streamRangeQuery(
 	ctx context.Context,
-	bucket, field, sensorID, measurement string,
-	duration time.Duration,
-	interval time.Duration,
-) []Measurement {
-	query := fmt.Sprintf(fromBucket, bucket)
-	query += fmt.Sprintf(queryLastDuration, duration)
-	query += fmt.Sprintf(filterSensorMAC, sensorID)
-	query += fmt.Sprintf(filterMeasurement, measurement)
-	query += fmt.Sprintf(filterField, field)
-	query += fmt.Sprintf(aggregate, interval)
-
-	log.Println("running query:", query)
+	fluxQuery *flux.Query,
+	rq RangeQuery,
+	rejected func(int),
+	fn func(Measurement) error,
+) error {
+	n, err := q.queryRejected(ctx, rq, []string{rq.SensorID})
+	if err != nil {
+		return err
+	}
+	rejected(n)
+
+	fluxQuery = q.filterRangeQuery(fluxQuery, rq)
+	agg := ""
+	if rq.Interval > 0 || rq.Months > 0 {
+		agg = rq.Aggregate.Function
+		fluxQuery = aggregateWindow(fluxQuery, rq, rq.Aggregate).Yield(agg)
+	}
+
+	var count, errorCount int
+	err = q.StreamQuery(ctx, fluxQuery, func(record *query.FluxRecord) error {
+		m, err := aggregateFromRecord(record, agg)
+		if m == nil || err != nil {
+			errorCount++
+			return nil
+		}
+		count++
+		return fn(m)
+	})
+
+	if errorCount > 0 {
+		log.Printf("%d records failed to be converted to measurements", errorCount)
+	}
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrNoData
+	}
+	return nil
+}
 
+// queryRejected counts the values of the field of rq from sensorIDs within the range of rq
+// which are outside the bounds of the field. The count is a query of its own, so that
+// filtering and counting can be pushed down to storage and the count is known before
+// the values are streamed. Nothing is queried if rq is raw or the field has no bounds.
+func (q *Querier) queryRejected(ctx context.Context, rq RangeQuery, sensorIDs []string) (int, error) {
+	query := q.rejectedQuery(rq, sensorIDs)
+	if query == nil {
+		return 0, nil
+	}
 	records, err := q.ExecuteQuery(ctx, query)
 	if err != nil {
-		log.Println("error running query:", err)
-		return nil
+		return 0, err
 	}
+	// one count per series
+	var rejected int
+	for _, record := range records {
+		n, _ := numericValue(record.Value())
+		rejected += int(n)
+	}
+	return rejected, nil
+}
 
-	if len(records) < 1 {
-		log.Println("no records found")
+// rejectedQuery builds the query of queryRejected, nil if there is nothing to count.
+func (q *Querier) rejectedQuery(rq RangeQuery, sensorIDs []string) *flux.Query {
+	bounds, ok := fieldBounds(rq.Field)
+	if !ok || rq.Raw {
 		return nil
 	}
+	query := withRange(flux.From(q.bucket), rq).
+		FilterIn("sensormac", sensorIDs).
+		FilterEquals("_measurement", q.measurement)
+	return filterField(query, rq.Field, sensorIDs).
+		Add(`|> filter(fn: (r) => float(v: r._value) < ? or float(v: r._value) > ?)`, bounds.Min, bounds.Max).
+		Add(`|> count()`)
+}
 
-	var measurements []Measurement
-	var errorCount int
-	for _, record := range records {
-		m, err := MeasurementFromRecord(record)
+// QueryGroupRange gets the measurements of rq from each of sensorIDs in one query,
+// aggregated into the windows of rq separately for each sensor.
+// Values outside the bounds of the field are counted across the sensors with a separate query.
+func (q *Querier) QueryGroupRange(ctx context.Context, rq RangeQuery, sensorIDs []string) ([]Measurement, int, error) {
+	rejected, err := q.queryRejected(ctx, rq, sensorIDs)
+	if err != nil {
+		return nil, 0, err
+	}
+	fluxQuery := withRange(flux.From(q.bucket), rq).
+		FilterIn("sensormac", sensorIDs).
+		FilterEquals("_measurement", q.measurement)
+	fluxQuery = filterBounds(filterField(fluxQuery, rq.Field, sensorIDs), rq).
+		Add(`|> group(columns: ["sensormac", "_field"])`)
+	agg := rq.Aggregate.Function
+	fluxQuery = aggregateWindow(fluxQuery, rq, rq.Aggregate).Yield(agg)
+
+	var (
+		measurements []Measurement
+		errorCount   int
+	)
+	err = q.StreamQuery(ctx, fluxQuery, func(record *query.FluxRecord) error {
+		m, err := aggregateFromRecord(record, agg)
 		if m == nil || err != nil {
 			errorCount++
-			continue
+			return nil
 		}
 		measurements = append(measurements, m)
+		return nil
+	})
+
+	if errorCount > 0 {
+		log.Printf("%d records failed to be converted to measurements", errorCount)
+	}
+	if err != nil {
+		return nil, 0, err
 	}
+	if len(measurements) == 0 {
+		return nil, 0, ErrNoData
+	}
+	return measurements, rejected, nil
+}
 
-	log.Printf("%d records failed to be converted to measurements", errorCount)
+// withRange limits query to the time range of rq.
+func withRange(query *flux.Query, rq RangeQuery) *flux.Query {
+	if rq.Since > 0 {
+		return query.RangeSince(rq.Since)
+	}
+	return query.Range(rq.Start, rq.Stop)
+}
 
-	return measurements
+func collectMeasurements(stream func(rejected func(int), fn func(Measurement) error) error) ([]Measurement, int, error) {
+	var (
+		measurements []Measurement
+		rejected     int
+	)
+	err := stream(func(n int) {
+		rejected = n
+	}, func(m Measurement) error {
+		measurements = append(measurements, m)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return measurements, rejected, nil
 }
 
-func (q *Querier) QueryBetweenTimes(
-	ctx context.Context,
-	bucket, field, sensorID, measurement string,
-	start, stop time.Time,
-	interval time.Duration,
-) []Measurement {
-	query := fmt.Sprintf(fromBucket, bucket)
-	query += fmt.Sprintf(queryBetweenTimes, start.Format(time.RFC3339Nano), stop.Format(time.RFC3339Nano))
-	query += fmt.Sprintf(filterSensorMAC, sensorID)
-	query += fmt.Sprintf(filterMeasurement, measurement)
-	query += fmt.Sprintf(filterField, field)
-	query += fmt.Sprintf(aggregate, interval)
-
-	log.Println("running query:", query)
+// QueryEnvelope computes min, mean and max of each window in one query
+// by pivoting the three aggregates into columns of the same row.
+// Values outside the bounds of the field are counted with a separate query.
+func (q *Querier) QueryEnvelope(ctx context.Context, rq RangeQuery) ([]EnvelopePoint, int, error) {
+	rejected, err := q.queryRejected(ctx, rq, []string{rq.SensorID})
+	if err != nil {
+		return nil, 0, err
+	}
+	query := withRange(flux.New().Add(`data = from(bucket: ?)`, q.bucket), rq)
+	query = q.filterRangeQuery(query, rq).Add(`|> toFloat()`)
+	for _, fn := range []string{"min", "mean", "max"} {
+		query = aggregateWindow(query.Add(fn+`Values = data`), rq, Aggregate{Function: fn}).
+			Add(`|> set(key: "aggregate", value: ?)`, fn)
+	}
+	query = query.
+		Add(`union(tables: [minValues, meanValues, maxValues])`).
+		Add(`|> pivot(rowKey: ["_time"], columnKey: ["aggregate"], valueColumn: "_value")`).
+		Yield("envelope")
 
 	records, err := q.ExecuteQuery(ctx, query)
 	if err != nil {
-		log.Println("error running query:", err)
-		return nil
+		return nil, 0, err
+	}
+
+	var points []EnvelopePoint
+	for _, record := range records {
+		min, minOK := numericValue(record.ValueByKey("min"))
+		mean, meanOK := numericValue(record.ValueByKey("mean"))
+		max, maxOK := numericValue(record.ValueByKey("max"))
+		if !minOK || !meanOK || !maxOK {
+			continue
+		}
+		t := record.Time()
+		points = append(points, EnvelopePoint{
+			SensorID: rq.SensorID,
+			Field:    rq.Field,
+			Min:      calibrateValue(rq.SensorID, rq.Field, t, min, "min"),
+			Mean:     calibrateValue(rq.SensorID, rq.Field, t, mean, "mean"),
+			Max:      calibrateValue(rq.SensorID, rq.Field, t, max, "max"),
+			Time:     t,
+		})
+	}
+
+	if len(points) == 0 {
+		return nil, 0, ErrNoData
+	}
+	return points, rejected, nil
+}
+
+// QueryStats computes summary statistics between rq.Start and rq.Stop,
+// each statistic being yielded as a separate result of the same query.
+// The mean, median and standard deviation are calibrated as a whole,
+// so ErrCalibrationChanged is returned if the calibration changes within the range.
+func (q *Querier) QueryStats(ctx context.Context, rq RangeQuery) (Stats, error) {
+	if calibration.Changes(rq.SensorID, rq.Field, rq.Start, rq.Stop) {
+		return Stats{}, ErrCalibrationChanged
+	}
+	query := withRange(flux.New().Add(`data = from(bucket: ?)`, q.bucket), rq)
+	query = q.filterRangeQuery(query, rq).
+		Add(`|> group(columns: ["sensormac", "_field"])`).
+		Add(`|> toFloat()`)
+	for _, fn := range []string{"count", "min", "max", "mean", "median", "stddev", "first", "last"} {
+		query = query.Add(`data |> ` + fn + `()`).Yield(fn)
+	}
+
+	records, err := q.ExecuteQuery(ctx, query)
+	if err != nil {
+		return Stats{}, err
 	}
 
-	if len(records) < 1 {
-		log.Println("no records found")
+	stats := Stats{
+		SensorID: rq.SensorID,
+		Field:    rq.Field,
+		From:     rq.Start,
+		To:       rq.Stop,
+	}
+	for _, record := range records {
+		v, ok := numericValue(record.Value())
+		if !ok {
+			continue
+		}
+		t := record.Time()
+		switch record.Result() {
+		case "count", "mean", "median", "stddev":
+			// these have no time, but the same calibration applies throughout the range
+			t = rq.Start
+		}
+		v = calibrateValue(rq.SensorID, rq.Field, t, v, record.Result())
+		switch record.Result() {
+		case "count":
+			stats.Count = int(v)
+		case "mean":
+			stats.Mean = v
+		case "median":
+			stats.Median = v
+		case "stddev":
+			stats.StdDev = v
+		case "min", "max", "first", "last":
+			m, err := NewMeasurement(rq.Field, rq.SensorID, v, record.Time())
+			if err != nil {
+				return Stats{}, err
+			}
+			switch record.Result() {
+			case "min":
+				stats.Min = m
+			case "max":
+				stats.Max = m
+			case "first":
+				stats.First = m
+			case "last":
+				stats.Last = m
+			}
+		}
+	}
+
+	if stats.Count == 0 {
+		return Stats{}, ErrNoData
+	}
+	return stats, nil
+}
+
+// aggregateWindow appends the aggregateWindow call matching agg and the windows of rq to query.
+func aggregateWindow(query *flux.Query, rq RangeQuery, agg Aggregate) *flux.Query {
+	window := flux.Window{Every: rq.Interval, Months: rq.Months}
+	if rq.Months == 0 && rq.Interval >= week && rq.Interval%week == 0 {
+		// Flux weeks start on Thursday, the weekday of the Unix epoch
+		window.Offset = 4 * day
+	}
+	if rq.Location != nil && rq.Location != time.UTC {
+		query = query.Location(rq.Location.String())
+	}
+
+	if agg.Function == "quantile" {
+		return query.AggregateWindowQuantile(window, agg.Quantile, false)
+	}
+	return query.AggregateWindow(window, agg.Function, false)
+}
+
+// QuerySensors lists sensors which have reported data during the past year,
+// along with their fields and when they were first and last seen.
+// Sensors are listed with schema.tagValues. Fields and first and last seen times come
+// from the first and last point of each series, which InfluxDB reads from its index
+// without scanning the points in between, as long as the series are not regrouped.
+func (q *Querier) QuerySensors(ctx context.Context) ([]SensorInfo, error) {
+	query := flux.New().
+		Import("influxdata/influxdb/schema").
+		Add(
+			`schema.tagValues(bucket: ?, tag: "sensormac", predicate: (r) => r["_measurement"] == ?, start: ?) |> yield(name: "sensors")`,
+			q.bucket, q.measurement, -discoveryLookback,
+		).
+		Add(`data = from(bucket: ?)`, q.bucket).
+		RangeSince(discoveryLookback).
+		FilterEquals("_measurement", q.measurement).
+		Add(`data |> first() |> keep(columns: ["_time", "_field", "sensormac"]) |> yield(name: "first")`).
+		Add(`data |> last() |> keep(columns: ["_time", "_field", "sensormac"]) |> yield(name: "last")`)
+
+	records, err := q.ExecuteQuery(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return sensorsFromRecords(records)
+}
+
+// QuerySnapshot gets the latest value of each snapshot field for every sensor
+// which has reported during the past year, like QueryLastValue.
+// Values and their timestamps are pivoted into one row per sensor in separate results.
+// Values are converted to floats before regrouping, as Flux cannot merge tables with
+// differently typed values, e.g. integer pressure and float temperature.
+func (q *Querier) QuerySnapshot(ctx context.Context) ([]Snapshot, error) {
+	records, err := q.ExecuteQuery(ctx, q.snapshotQuery())
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshotsFromRecords(records)
+}
+
+// snapshotQuery builds the query of QuerySnapshot.
+func (q *Querier) snapshotQuery() *flux.Query {
+	return flux.New().
+		Add(`latest = from(bucket: ?)`, q.bucket).
+		RangeSince(discoveryLookback).
+		FilterEquals("_measurement", q.measurement).
+		FilterIn("_field", SnapshotFields).
+		Last().
+		Add(`|> toFloat()`).
+		Add(`|> group(columns: ["sensormac"])`).
+		Add(`latest |> pivot(rowKey: ["sensormac"], columnKey: ["_field"], valueColumn: "_value") |> yield(name: "values")`).
+		Add(`latest |> pivot(rowKey: ["sensormac"], columnKey: ["_field"], valueColumn: "_time") |> yield(name: "times")`)
+}
+
+// QueryActivity gets the distinct times each sensor has reported during the past window.
+// Records are streamed, but the distinct times are collected in memory,
+// so the window must be bounded by the caller.
+func (q *Querier) QueryActivity(ctx context.Context, window time.Duration) ([]SensorActivity, error) {
+	fluxQuery := flux.From(q.bucket).
+		RangeSince(window).
+		FilterEquals("_measurement", q.measurement).
+		Add(`|> keep(columns: ["_time", "sensormac"])`).
+		Add(`|> group(columns: ["sensormac"])`).
+		Add(`|> unique(column: "_time")`).
+		Add(`|> sort(columns: ["_time"])`)
+
+	reports := make(map[string][]time.Time)
+	err := q.StreamQuery(ctx, fluxQuery, func(record *query.FluxRecord) error {
+		mac, ok := record.ValueByKey("sensormac").(string)
+		if !ok || mac == "" {
+			return nil
+		}
+		reports[mac] = append(reports[mac], record.Time())
 		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	activity := make([]SensorActivity, 0, len(reports))
+	for mac, times := range reports {
+		activity = append(activity, SensorActivity{ID: mac, Reports: times})
 	}
+	sort.Slice(activity, func(i, j int) bool {
+		return activity[i].ID < activity[j].ID
+	})
+	return activity, nil
+}
+
+// QueryFields lists fields sensorID has reported during the past year,
+// along with the fields which can be derived from them.
+func (q *Querier) QueryFields(ctx context.Context, sensorID string) ([]string, error) {
+	query := flux.New().Import("influxdata/influxdb/schema").Add(
+		`schema.fieldKeys(bucket: ?, predicate: (r) => r["_measurement"] == ? and r["sensormac"] == ?, start: ?)`,
+		q.bucket, q.measurement, sensorID, -discoveryLookback,
+	)
 
-	var measurements []Measurement
-	var errorCount int
+	records, err := q.ExecuteQuery(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, err := stringsFromRecords(records)
+	if err != nil {
+		return nil, err
+	}
+	return withDerivedFields(fields), nil
+}
+
+func stringsFromRecords(records []*query.FluxRecord) ([]string, error) {
+	var values []string
 	for _, record := range records {
-		m, err := MeasurementFromRecord(record)
-		if m == nil || err != nil {
-			errorCount++
+		v, ok := record.Value().(string)
+		if !ok || v == "" {
 			continue
 		}
-		measurements = append(measurements, m)
+		values = append(values, v)
+	}
+
+	if len(values) == 0 {
+		return nil, ErrNoData
+	}
+	return values, nil
+}
+
+func sensorsFromRecords(records []*query.FluxRecord) ([]SensorInfo, error) {
+	sensors := make(map[string]*SensorInfo)
+	fields := make(map[string]map[string]bool)
+	sensor := func(mac string) *SensorInfo {
+		info, ok := sensors[mac]
+		if !ok {
+			info = &SensorInfo{ID: mac}
+			sensors[mac] = info
+			fields[mac] = make(map[string]bool)
+		}
+		return info
+	}
+	for _, record := range records {
+		if record.Result() == "sensors" {
+			if mac, ok := record.Value().(string); ok && mac != "" {
+				sensor(mac)
+			}
+			continue
+		}
+
+		mac, ok := record.ValueByKey("sensormac").(string)
+		if !ok || mac == "" || record.Field() == "" {
+			continue
+		}
+		info := sensor(mac)
+
+		switch t := record.Time(); record.Result() {
+		case "first":
+			if info.FirstSeen.IsZero() || t.Before(info.FirstSeen) {
+				info.FirstSeen = t
+			}
+		case "last":
+			if t.After(info.LastSeen) {
+				info.LastSeen = t
+			}
+			// a field is in as many series as there are tag combinations reporting it
+			if !fields[mac][record.Field()] {
+				fields[mac][record.Field()] = true
+				info.Fields = append(info.Fields, record.Field())
+			}
+		}
+	}
+
+	if len(sensors) == 0 {
+		return nil, ErrNoData
+	}
+
+	infos := make([]SensorInfo, 0, len(sensors))
+	for _, info := range sensors {
+		sort.Strings(info.Fields)
+		infos = append(infos, *info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ID < infos[j].ID
+	})
+	return infos, nil
+}
+
+func snapshotsFromRecords(records []*query.FluxRecord) ([]Snapshot, error) {
+	values := make(map[string]map[string]interface{})
+	times := make(map[string]map[string]interface{})
+	for _, record := range records {
+		mac, ok := record.ValueByKey("sensormac").(string)
+		if !ok || mac == "" {
+			continue
+		}
+		switch record.Result() {
+		case "values":
+			values[mac] = record.Values()
+		case "times":
+			times[mac] = record.Values()
+		}
 	}
 
-	log.Printf("%d records failed to be converted to measurements", errorCount)
+	var snapshots []Snapshot
+	for mac, row := range values {
+		snapshot := Snapshot{
+			SensorID:     mac,
+			Measurements: make(map[string]Measurement),
+		}
+		for _, field := range SnapshotFields {
+			v, ok := numericValue(row[field])
+			if !ok {
+				continue
+			}
+			t, ok := times[mac][field].(time.Time)
+			if !ok {
+				continue
+			}
+			m, err := NewMeasurement(field, mac, v, t)
+			if err != nil {
+				log.Println("error converting snapshot value:", err)
+				continue
+			}
+			snapshot.Measurements[field] = calibrate(m, "")
+		}
+		if len(snapshot.Measurements) > 0 {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
 
-	return measurements
+	if len(snapshots) == 0 {
+		return nil, ErrNoData
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].SensorID < snapshots[j].SensorID
+	})
+	return snapshots, nil
 }
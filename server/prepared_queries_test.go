@@ -0,0 +1,83 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LassiHeikkila/mokki-cloud/server/flux"
+)
+
+func TestRejectedQueryCountsOutOfBounds(t *testing.T) {
+	q := &Querier{bucket: "bucket", measurement: "ruuvi"}
+	start := time.Date(2022, 10, 30, 0, 0, 0, 0, time.UTC)
+	rq := RangeQuery{Field: "temperature", Start: start, Stop: start.Add(time.Hour)}
+
+	query := q.rejectedQuery(rq, []string{"a", "b"})
+	if err := query.Err(); err != nil {
+		t.Fatal(err)
+	}
+	text := query.String()
+	for _, want := range []string{
+		`r["sensormac"] == "a" or r["sensormac"] == "b"`,
+		`float(v: r._value) < -60.0 or float(v: r._value) > 100.0`,
+		`|> count()`,
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("query lacks %s:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "reduce") || strings.Contains(text, "group()") {
+		t.Errorf("count is not pushed down:\n%s", text)
+	}
+
+	rq.Raw = true
+	if query := q.rejectedQuery(rq, []string{"a"}); query != nil {
+		t.Errorf("raw query counts rejected values:\n%s", query)
+	}
+	rq.Raw = false
+	rq.Field = "movements"
+	if query := q.rejectedQuery(rq, []string{"a"}); query != nil {
+		t.Errorf("field without bounds counts rejected values:\n%s", query)
+	}
+}
+
+func TestParameterizedQueriesInlineNumbers(t *testing.T) {
+	start := time.Date(2022, 10, 30, 0, 0, 0, 0, time.UTC)
+	rq := RangeQuery{Field: "temperature", Start: start, Stop: start.Add(time.Hour), Interval: time.Minute}
+	tests := []struct {
+		query *flux.Query
+		want  string
+	}{
+		{filterBounds(flux.From("bucket").Range(rq.Start, rq.Stop), rq), `float(v: r._value) >= -60.0 and float(v: r._value) <= 100.0`},
+		{filterMovements(flux.From("bucket")), `r.counterStep + 255`},
+		{aggregateWindow(flux.From("bucket"), rq, Aggregate{Function: "quantile", Quantile: 0.95}), `quantile(q: 0.95, column: column)`},
+	}
+	for _, tt := range tests {
+		if err := tt.query.Err(); err != nil {
+			t.Fatal(err)
+		}
+		text, params := tt.query.Parameterized()
+		if !strings.Contains(text, tt.want) {
+			t.Errorf("query lacks %s:\n%s", tt.want, text)
+		}
+		for name, v := range params {
+			if _, ok := v.(string); !ok {
+				t.Errorf("params[%s] = %#v is not a string", name, v)
+			}
+		}
+	}
+}
+
+func TestSnapshotLooksBackLikeLatest(t *testing.T) {
+	q := &Querier{bucket: "bucket", measurement: "ruuvi"}
+	query := q.snapshotQuery()
+	if err := query.Err(); err != nil {
+		t.Fatal(err)
+	}
+	// a sensor which has gone quiet has the same latest values in the snapshot as from Latest
+	lookback := strings.TrimSpace(flux.New().RangeSince(discoveryLookback).String())
+	if text := query.String(); !strings.Contains(text, lookback) {
+		t.Errorf("snapshot query lacks %s:\n%s", lookback, text)
+	}
+}
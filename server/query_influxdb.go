@@ -3,18 +3,27 @@ package server
 import (
 	"context"
 	"errors"
+	"log"
+	"time"
 
 	influxdb "github.com/influxdata/influxdb-client-go/v2"
 	"github.com/influxdata/influxdb-client-go/v2/api"
 	"github.com/influxdata/influxdb-client-go/v2/api/query"
+
+	"github.com/LassiHeikkila/mokki-cloud/server/flux"
 )
 
+// Querier is a Store backed by an InfluxDB instance.
 type Querier struct {
 	c influxdb.Client
 	q api.QueryAPI
+
+	bucket      string
+	measurement string
+	useParams   bool
 }
 
-func NewQuerier(serverURL, authToken, org string) *Querier {
+func NewQuerier(serverURL, authToken, org, bucket, measurement string) *Querier {
 	client := influxdb.NewClient(serverURL, authToken)
 	if client == nil {
 		return nil
@@ -25,8 +34,10 @@ func NewQuerier(serverURL, authToken, org string) *Querier {
 	}
 
 	return &Querier{
-		c: client,
-		q: queryAPI,
+		c:           client,
+		q:           queryAPI,
+		bucket:      bucket,
+		measurement: measurement,
 	}
 }
 
@@ -38,21 +49,104 @@ func (q *Querier) Close() error {
 	return nil
 }
 
-func (q *Querier) ExecuteQuery(ctx context.Context, queryToRun string) ([]*query.FluxRecord, error) {
+// UseParams controls whether query values are sent as InfluxDB query parameters
+// instead of being inlined into the query as escaped literals.
+// Query parameters are only supported by InfluxDB Cloud.
+func (q *Querier) UseParams(enabled bool) {
+	q.useParams = enabled
+}
+
+func (q *Querier) ExecuteQuery(ctx context.Context, queryToRun *flux.Query) ([]*query.FluxRecord, error) {
+	var records []*query.FluxRecord
+	err := q.StreamQuery(ctx, queryToRun, func(r *query.FluxRecord) error {
+		records = append(records, r)
+		return nil
+	})
+	return records, err
+}
+
+// StreamQuery runs queryToRun and calls fn for each record as it is read from the response,
+// without buffering the whole result. Streaming stops at the first error returned by fn.
+func (q *Querier) StreamQuery(
+	ctx context.Context,
+	queryToRun *flux.Query,
+	fn func(*query.FluxRecord) error,
+) error {
 	if q.q == nil {
-		return nil, errors.New("query api not available")
+		return errors.New("query api not available")
+	}
+	if err := queryToRun.Err(); err != nil {
+		return err
+	}
+
+	var (
+		result *api.QueryTableResult
+		err    error
+	)
+	if q.useParams {
+		text, params := queryToRun.Parameterized()
+		log.Println("running query:", text, "with params:", params)
+		result, err = q.q.QueryWithParams(ctx, text, params)
+	} else {
+		text := queryToRun.String()
+		log.Println("running query:", text)
+		result, err = q.q.Query(ctx, text)
 	}
-	result, err := q.q.Query(ctx, queryToRun)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	defer result.Close()
 
-	var records []*query.FluxRecord
 	for result.Next() {
-		records = append(records, result.Record())
+		if err := fn(result.Record()); err != nil {
+			return err
+		}
 	}
-	if result.Err() != nil {
-		return records, result.Err()
+	return result.Err()
+}
+
+func (q *Querier) Latest(ctx context.Context, field, id string) (Measurement, error) {
+	return q.QueryLastValue(ctx, field, id)
+}
+
+func (q *Querier) Range(ctx context.Context, query RangeQuery) ([]Measurement, int, error) {
+	if query.Since > 0 {
+		return q.QueryLastDuration(ctx, query)
 	}
-	return records, nil
+	return q.QueryBetweenTimes(ctx, query)
+}
+
+func (q *Querier) StreamRange(ctx context.Context, query RangeQuery, rejected func(int), fn func(Measurement) error) error {
+	if query.Since > 0 {
+		return q.StreamLastDuration(ctx, query, rejected, fn)
+	}
+	return q.StreamBetweenTimes(ctx, query, rejected, fn)
+}
+
+func (q *Querier) Envelope(ctx context.Context, query RangeQuery) ([]EnvelopePoint, int, error) {
+	return q.QueryEnvelope(ctx, query)
+}
+
+func (q *Querier) Stats(ctx context.Context, query RangeQuery) (Stats, error) {
+	return q.QueryStats(ctx, query)
+}
+
+func (q *Querier) GroupRange(ctx context.Context, query RangeQuery, sensorIDs []string) ([]Measurement, int, error) {
+	return q.QueryGroupRange(ctx, query, sensorIDs)
+}
+
+func (q *Querier) Sensors(ctx context.Context) ([]SensorInfo, error) {
+	return q.QuerySensors(ctx)
+}
+
+func (q *Querier) Fields(ctx context.Context, id string) ([]string, error) {
+	return q.QueryFields(ctx, id)
+}
+
+func (q *Querier) Snapshot(ctx context.Context) ([]Snapshot, error) {
+	return q.QuerySnapshot(ctx)
+}
+
+func (q *Querier) Activity(ctx context.Context, window time.Duration) ([]SensorActivity, error) {
+	return q.QueryActivity(ctx, window)
 }
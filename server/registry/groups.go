@@ -0,0 +1,119 @@
+package registry
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/LassiHeikkila/mokki-cloud/server/registry/internal"
+)
+
+var (
+	// ErrGroupNotFound is returned when no group has the given name.
+	ErrGroupNotFound = errors.New("group not found")
+	// ErrGroupExists is returned when adding a group with a name already in use.
+	ErrGroupExists = errors.New("group already exists")
+)
+
+// Group is a named set of sensors whose data can be aggregated together, e.g. the sensors of one building.
+// Members are MAC addresses. Aliases given as members are resolved when the group is stored.
+type Group struct {
+	Name        string   `json:"name"`
+	DisplayName string   `json:"displayName,omitempty"`
+	Members     []string `json:"members"`
+}
+
+func (g *Group) normalize() error {
+	var err error
+	switch {
+	case g.Name == "":
+		err = errors.New("missing name")
+	case !aliasPattern.MatchString(g.Name):
+		err = errors.New("name may only contain letters, digits, - and _")
+	case len(g.Members) == 0:
+		err = errors.New("group must have members")
+	}
+	if err != nil {
+		return validationError{err}
+	}
+	members := make([]string, 0, len(g.Members))
+	seen := make(map[string]bool, len(g.Members))
+	for _, member := range g.Members {
+		if member == "" || strings.Contains(member, "/") {
+			return validationError{errors.New("invalid member: " + member)}
+		}
+		mac := Resolve(member)
+		if !seen[mac] {
+			seen[mac] = true
+			members = append(members, mac)
+		}
+	}
+	g.Members = members
+	return nil
+}
+
+// Groups returns all groups ordered by name.
+func Groups() []Group {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return append([]Group(nil), groups...)
+}
+
+// GetGroup returns the group with the given name.
+func GetGroup(name string) (Group, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	for _, g := range groups {
+		if g.Name == name {
+			return g, true
+		}
+	}
+	return Group{}, false
+}
+
+// AddGroup stores a new group and returns it with members resolved to MAC addresses.
+func AddGroup(g Group) (Group, error) {
+	if err := g.normalize(); err != nil {
+		return Group{}, err
+	}
+	if _, ok := GetGroup(g.Name); ok {
+		return Group{}, ErrGroupExists
+	}
+	err := internal.InsertGroup(databaseHandle, internal.GroupRow(g))
+	if errors.Is(err, internal.ErrDuplicateKey) {
+		return Group{}, ErrGroupExists
+	}
+	if err != nil {
+		return Group{}, err
+	}
+	return g, reload()
+}
+
+// UpdateGroup replaces the group with the name of g and returns it with members resolved to MAC addresses.
+func UpdateGroup(g Group) (Group, error) {
+	if err := g.normalize(); err != nil {
+		return Group{}, err
+	}
+	err := internal.UpdateGroup(databaseHandle, internal.GroupRow(g))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Group{}, ErrGroupNotFound
+	}
+	if err != nil {
+		return Group{}, err
+	}
+	return g, reload()
+}
+
+// RemoveGroup deletes the group with the given name. The member sensors are not affected.
+func RemoveGroup(name string) error {
+	err := internal.RemoveGroup(databaseHandle, name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrGroupNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return reload()
+}
@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// GroupRow is one row of the sensorgroups table along with the members of the group.
+type GroupRow struct {
+	Name        string
+	DisplayName string
+	Members     []string
+}
+
+func InsertGroup(db *sql.DB, row GroupRow) error {
+	if db == nil {
+		return errors.New("no database registered")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`INSERT INTO sensorgroups
+		(name, displayName)
+		VALUES (?, ?)`, row.Name, row.DisplayName); err != nil {
+		return constraintError(err)
+	}
+	if err := insertGroupMembers(tx, row); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func UpdateGroup(db *sql.DB, row GroupRow) error {
+	if db == nil {
+		return errors.New("no database registered")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE sensorgroups
+		SET displayName = ?
+		WHERE name = ?`, row.DisplayName, row.Name)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	if _, err := tx.Exec(`DELETE FROM sensorgroupmembers
+		WHERE groupName == ?`, row.Name); err != nil {
+		return err
+	}
+	if err := insertGroupMembers(tx, row); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func RemoveGroup(db *sql.DB, name string) error {
+	if db == nil {
+		return errors.New("no database registered")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// members are removed explicitly as foreign keys are not enforced unless enabled per connection
+	if _, err := tx.Exec(`DELETE FROM sensorgroupmembers
+		WHERE groupName == ?`, name); err != nil {
+		return err
+	}
+	res, err := tx.Exec(`DELETE FROM sensorgroups
+		WHERE name == ?`, name)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func GetGroups(db *sql.DB) ([]GroupRow, error) {
+	if db == nil {
+		return nil, errors.New("no database registered")
+	}
+
+	rows, err := db.Query(`SELECT g.name, g.displayName, m.mac FROM sensorgroups g
+		LEFT JOIN sensorgroupmembers m ON m.groupName = g.name
+		ORDER BY g.name, m.mac`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []GroupRow
+	for rows.Next() {
+		var (
+			name, displayName string
+			mac               sql.NullString
+		)
+		if err := rows.Scan(&name, &displayName, &mac); err != nil {
+			return nil, err
+		}
+		if len(groups) == 0 || groups[len(groups)-1].Name != name {
+			groups = append(groups, GroupRow{Name: name, DisplayName: displayName})
+		}
+		if mac.Valid {
+			last := &groups[len(groups)-1]
+			last.Members = append(last.Members, mac.String)
+		}
+	}
+
+	return groups, rows.Err()
+}
+
+func insertGroupMembers(tx *sql.Tx, row GroupRow) error {
+	stmt, err := tx.Prepare(`INSERT OR IGNORE INTO sensorgroupmembers
+		(groupName, mac)
+		VALUES (?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, mac := range row.Members {
+		if _, err := stmt.Exec(row.Name, mac); err != nil {
+			return err
+		}
+	}
+	return nil
+}
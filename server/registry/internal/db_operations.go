@@ -0,0 +1,174 @@
+package internal
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+var (
+	// ErrDuplicateKey is returned when inserting a row with the primary key of an existing row.
+	ErrDuplicateKey = errors.New("duplicate key")
+	// ErrDuplicateAlias is returned when a row would have the alias of another row.
+	ErrDuplicateAlias = errors.New("duplicate alias")
+)
+
+// Row is one row of the sensors table. Empty alias is stored as NULL to keep aliases unique.
+type Row struct {
+	MAC       string
+	Alias     string
+	Name      string
+	Room      string
+	Placement string
+	Notes     string
+	Icon      string
+}
+
+func InsertSensor(db *sql.DB, row Row) error {
+	if db == nil {
+		return errors.New("no database registered")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT INTO sensors
+		(mac, alias, name, room, placement, notes, icon)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(row.MAC, nullable(row.Alias), row.Name, row.Room, row.Placement, row.Notes, row.Icon); err != nil {
+		return constraintError(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func UpdateSensor(db *sql.DB, row Row) error {
+	if db == nil {
+		return errors.New("no database registered")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`UPDATE sensors
+		SET alias = ?, name = ?, room = ?, placement = ?, notes = ?, icon = ?
+		WHERE mac = ?`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.Exec(nullable(row.Alias), row.Name, row.Room, row.Placement, row.Notes, row.Icon, row.MAC)
+	if err != nil {
+		return constraintError(err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func RemoveSensor(db *sql.DB, mac string) error {
+	if db == nil {
+		return errors.New("no database registered")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`DELETE FROM sensors
+		WHERE mac == ?`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.Exec(mac)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func GetSensors(db *sql.DB) ([]Row, error) {
+	if db == nil {
+		return nil, errors.New("no database registered")
+	}
+
+	rows, err := db.Query(`SELECT mac, alias, name, room, placement, notes, icon FROM sensors
+		ORDER BY mac`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sensors []Row
+	for rows.Next() {
+		var (
+			row   Row
+			alias sql.NullString
+		)
+		if err := rows.Scan(&row.MAC, &alias, &row.Name, &row.Room, &row.Placement, &row.Notes, &row.Icon); err != nil {
+			return nil, err
+		}
+		row.Alias = alias.String
+		sensors = append(sensors, row)
+	}
+
+	return sensors, rows.Err()
+}
+
+func nullable(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// constraintError replaces errors of violated primary key and UNIQUE constraints
+// with ErrDuplicateKey and ErrDuplicateAlias respectively.
+func constraintError(err error) error {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return err
+	}
+	switch sqliteErr.ExtendedCode {
+	case sqlite3.ErrConstraintPrimaryKey:
+		return ErrDuplicateKey
+	case sqlite3.ErrConstraintUnique:
+		return ErrDuplicateAlias
+	}
+	return err
+}
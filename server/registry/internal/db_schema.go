@@ -0,0 +1,34 @@
+package internal
+
+const SensorsTableInitStmt = `
+CREATE TABLE IF NOT EXISTS "sensors"
+(
+	mac TEXT NOT NULL,
+	alias TEXT UNIQUE,
+	name TEXT NOT NULL DEFAULT '',
+	room TEXT NOT NULL DEFAULT '',
+	placement TEXT NOT NULL DEFAULT '',
+	notes TEXT NOT NULL DEFAULT '',
+	icon TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY (mac)
+);
+`
+
+const GroupsTableInitStmt = `
+CREATE TABLE IF NOT EXISTS "sensorgroups"
+(
+	name TEXT NOT NULL,
+	displayName TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY (name)
+);
+`
+
+const GroupMembersTableInitStmt = `
+CREATE TABLE IF NOT EXISTS "sensorgroupmembers"
+(
+	groupName TEXT NOT NULL,
+	mac TEXT NOT NULL,
+	PRIMARY KEY (groupName, mac),
+	FOREIGN KEY (groupName) REFERENCES sensorgroups (name) ON DELETE CASCADE
+);
+`
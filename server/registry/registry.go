@@ -0,0 +1,239 @@
+// Package registry keeps friendly names, locations and other metadata of sensors in the SQLite database
+// and resolves sensor aliases to MAC addresses.
+package registry
+
+import (
+	"database/sql"
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/LassiHeikkila/mokki-cloud/server/registry/internal"
+)
+
+var (
+	databaseHandle *sql.DB = nil
+
+	// sensors and groups cache the tables, which are small and read for every request resolving an alias
+	mu      sync.RWMutex
+	sensors = map[string]Sensor{}
+	aliases = map[string]string{}
+	groups  []Group
+)
+
+var (
+	// ErrNotFound is returned when no sensor has the given MAC address or alias.
+	ErrNotFound = errors.New("sensor not found")
+	// ErrExists is returned when adding a sensor which is already registered.
+	ErrExists = errors.New("sensor already registered")
+)
+
+const (
+	Indoor  = "indoor"
+	Outdoor = "outdoor"
+)
+
+// aliasPattern keeps aliases usable as path segments.
+var aliasPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// macPattern matches MAC addresses without separators or separated by -,
+// which aliasPattern would otherwise accept.
+var macPattern = regexp.MustCompile(`^[0-9A-Fa-f]{2}(-?[0-9A-Fa-f]{2}){5}$`)
+
+// Sensor is the metadata of the sensor with MAC address MAC.
+// Alias can be used in place of the MAC address in the API.
+// Placement is either "indoor" or "outdoor", or empty if unknown.
+type Sensor struct {
+	MAC       string `json:"mac"`
+	Alias     string `json:"alias,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Room      string `json:"room,omitempty"`
+	Placement string `json:"placement,omitempty"`
+	Notes     string `json:"notes,omitempty"`
+	Icon      string `json:"icon,omitempty"`
+}
+
+func (s Sensor) validate() error {
+	var err error
+	switch {
+	case s.MAC == "":
+		err = errors.New("missing mac")
+	case strings.Contains(s.MAC, "/"):
+		err = errors.New("mac must not contain /")
+	case s.Alias != "" && !aliasPattern.MatchString(s.Alias):
+		err = errors.New("alias may only contain letters, digits, - and _")
+	case macPattern.MatchString(s.Alias):
+		err = errors.New("alias must not look like a MAC address")
+	case s.Placement != "" && s.Placement != Indoor && s.Placement != Outdoor:
+		err = errors.New("placement must be indoor or outdoor")
+	}
+	if err != nil {
+		return validationError{err}
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	if s.Alias == "" {
+		return nil
+	}
+	if mac, ok := aliases[s.Alias]; ok && mac != s.MAC {
+		return validationError{errors.New("alias already in use")}
+	}
+	if _, ok := sensors[s.Alias]; ok && s.Alias != s.MAC {
+		return validationError{errors.New("alias is the MAC address of another sensor")}
+	}
+	return nil
+}
+
+func RegisterDatabase(db *sql.DB) {
+	databaseHandle = db
+}
+
+// InitializeDatabase creates the sensors and group tables if needed and loads the sensors and groups.
+func InitializeDatabase() error {
+	if databaseHandle == nil {
+		return errors.New("no database registered")
+	}
+
+	for _, stmt := range []string{
+		internal.SensorsTableInitStmt,
+		internal.GroupsTableInitStmt,
+		internal.GroupMembersTableInitStmt,
+	} {
+		if _, err := databaseHandle.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	return reload()
+}
+
+// Sensors returns all registered sensors ordered by MAC address.
+func Sensors() []Sensor {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	list := make([]Sensor, 0, len(sensors))
+	for _, s := range sensors {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].MAC < list[j].MAC })
+	return list
+}
+
+// Get returns the sensor with the given MAC address or alias.
+func Get(idOrAlias string) (Sensor, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if mac, ok := aliases[idOrAlias]; ok {
+		idOrAlias = mac
+	}
+	s, ok := sensors[idOrAlias]
+	return s, ok
+}
+
+// Resolve returns the MAC address of the sensor with alias idOrAlias.
+// Anything else is returned as is, so that MAC addresses of unregistered sensors keep working.
+func Resolve(idOrAlias string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if mac, ok := aliases[idOrAlias]; ok {
+		return mac
+	}
+	return idOrAlias
+}
+
+// Add registers a new sensor.
+func Add(s Sensor) error {
+	if err := s.validate(); err != nil {
+		return err
+	}
+	if _, ok := Get(s.MAC); ok {
+		return ErrExists
+	}
+	err := internal.InsertSensor(databaseHandle, internal.Row(s))
+	if errors.Is(err, internal.ErrDuplicateKey) {
+		return ErrExists
+	}
+	if errors.Is(err, internal.ErrDuplicateAlias) {
+		return validationError{errors.New("alias already in use")}
+	}
+	if err != nil {
+		return err
+	}
+	return reload()
+}
+
+// Update replaces the metadata of the sensor with the MAC address of s.
+func Update(s Sensor) error {
+	if err := s.validate(); err != nil {
+		return err
+	}
+	err := internal.UpdateSensor(databaseHandle, internal.Row(s))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if errors.Is(err, internal.ErrDuplicateAlias) {
+		return validationError{errors.New("alias already in use")}
+	}
+	if err != nil {
+		return err
+	}
+	return reload()
+}
+
+// Remove deletes the sensor with the given MAC address from the registry.
+func Remove(mac string) error {
+	err := internal.RemoveSensor(databaseHandle, mac)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return reload()
+}
+
+// IsValidationError tells whether err was caused by an invalid sensor rather than the database.
+func IsValidationError(err error) bool {
+	var v validationError
+	return errors.As(err, &v)
+}
+
+type validationError struct {
+	error
+}
+
+func reload() error {
+	rows, err := internal.GetSensors(databaseHandle)
+	if err != nil {
+		return err
+	}
+	loadedSensors := make(map[string]Sensor, len(rows))
+	loadedAliases := make(map[string]string, len(rows))
+	for _, row := range rows {
+		loadedSensors[row.MAC] = Sensor(row)
+		if row.Alias != "" {
+			loadedAliases[row.Alias] = row.MAC
+		}
+	}
+	groupRows, err := internal.GetGroups(databaseHandle)
+	if err != nil {
+		return err
+	}
+	loadedGroups := make([]Group, 0, len(groupRows))
+	for _, row := range groupRows {
+		loadedGroups = append(loadedGroups, Group(row))
+	}
+
+	mu.Lock()
+	sensors = loadedSensors
+	aliases = loadedAliases
+	groups = loadedGroups
+	mu.Unlock()
+	return nil
+}
@@ -0,0 +1,72 @@
+package registry
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/LassiHeikkila/mokki-cloud/server/registry/internal"
+)
+
+func openTestDatabase(t *testing.T) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection to :memory: has its own database
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() {
+		db.Close()
+		databaseHandle = nil
+	})
+	RegisterDatabase(db)
+	if err := InitializeDatabase(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAliasValidation(t *testing.T) {
+	openTestDatabase(t)
+	if err := Add(Sensor{MAC: "AA:BB:CC:DD:EE:01", Alias: "kitchen"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, alias := range []string{"AABBCCDDEE02", "aabbccddee02", "AA-BB-CC-DD-EE-02", "AA:BB:CC:DD:EE:02", "kitchen"} {
+		err := Add(Sensor{MAC: "AA:BB:CC:DD:EE:02", Alias: alias})
+		if !IsValidationError(err) {
+			t.Errorf("alias %q: got %v, want validation error", alias, err)
+		}
+	}
+	for _, alias := range []string{"sauna", "AABBCCDDEE", "sauna-2"} {
+		if err := Update(Sensor{MAC: "AA:BB:CC:DD:EE:01", Alias: alias}); err != nil {
+			t.Errorf("alias %q: %v", alias, err)
+		}
+	}
+}
+
+func TestConstraintErrors(t *testing.T) {
+	openTestDatabase(t)
+	if err := Add(Sensor{MAC: "AA:BB:CC:DD:EE:01", Alias: "kitchen"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// bypass the cache, as if another request had registered the sensors in between
+	err := internal.InsertSensor(databaseHandle, internal.Row{MAC: "AA:BB:CC:DD:EE:01"})
+	if !errors.Is(err, internal.ErrDuplicateKey) {
+		t.Errorf("duplicate MAC: got %v, want %v", err, internal.ErrDuplicateKey)
+	}
+	err = internal.InsertSensor(databaseHandle, internal.Row{MAC: "AA:BB:CC:DD:EE:02", Alias: "kitchen"})
+	if !errors.Is(err, internal.ErrDuplicateAlias) {
+		t.Errorf("duplicate alias: got %v, want %v", err, internal.ErrDuplicateAlias)
+	}
+	err = internal.InsertGroup(databaseHandle, internal.GroupRow{Name: "upstairs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AddGroup(Group{Name: "upstairs", Members: []string{"kitchen"}}); !errors.Is(err, ErrGroupExists) {
+		t.Errorf("duplicate group: got %v, want %v", err, ErrGroupExists)
+	}
+}
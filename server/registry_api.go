@@ -0,0 +1,142 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/LassiHeikkila/mokki-cloud/server/registry"
+)
+
+// HandleRegistry lists registered sensors on GET and registers one on POST.
+// Registering requires the admin token.
+func HandleRegistry(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	if !Authenticated(w, req) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	switch req.Method {
+	case http.MethodGet:
+		writeJSON(w, registry.Sensors())
+	case http.MethodPost:
+		if !IsAdmin(req) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		sensor, err := readRegistrySensor(req)
+		if err != nil {
+			log.Println("error reading sensor from request:", err)
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if err := registry.Add(sensor); err != nil {
+			writeRegistryError(w, err)
+			return
+		}
+		b, err := json.Marshal(sensor)
+		if err != nil {
+			log.Println("error marshalling data:", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write(b)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleRegistrySensor returns a registered sensor on GET, replaces it on PUT and removes it on DELETE.
+// The sensor can be given by its MAC address or alias. Replacing and removing require the admin token.
+func HandleRegistrySensor(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	if !Authenticated(w, req) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	mac, err := getSensorIDFromRegistryPath(req.URL.Path)
+	if err != nil {
+		log.Printf("error getting sensor id from request path (%s): %s\n", req.URL.Path, err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if req.Method == http.MethodPut || req.Method == http.MethodDelete {
+		if !IsAdmin(req) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+	}
+	switch req.Method {
+	case http.MethodGet:
+		sensor, ok := registry.Get(mac)
+		if !ok {
+			writeRegistryError(w, registry.ErrNotFound)
+			return
+		}
+		writeJSON(w, sensor)
+	case http.MethodPut:
+		sensor, err := readRegistrySensor(req)
+		if err != nil {
+			log.Println("error reading sensor from request:", err)
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		sensor.MAC = mac
+		if err := registry.Update(sensor); err != nil {
+			writeRegistryError(w, err)
+			return
+		}
+		writeJSON(w, sensor)
+	case http.MethodDelete:
+		if err := registry.Remove(mac); err != nil {
+			writeRegistryError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func readRegistrySensor(req *http.Request) (registry.Sensor, error) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return registry.Sensor{}, err
+	}
+	var sensor registry.Sensor
+	if err := json.Unmarshal(b, &sensor); err != nil {
+		return registry.Sensor{}, err
+	}
+	return sensor, nil
+}
+
+func writeRegistryError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, registry.ErrNotFound):
+		http.Error(w, "sensor not found", http.StatusNotFound)
+	case errors.Is(err, registry.ErrExists):
+		http.Error(w, "sensor already registered", http.StatusConflict)
+	case registry.IsValidationError(err):
+		log.Println("invalid sensor:", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+	default:
+		log.Println("error storing sensor:", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+	}
+}
+
+func getSensorIDFromRegistryPath(path string) (string, error) {
+	// /api/registry/{id}
+	// id is third item, but split counts the empty value before the first /
+	segments := strings.Split(path, "/")
+	if len(segments) != 4 || segments[3] == "" {
+		return "", fmt.Errorf("malformed path: %v", segments)
+	}
+	return registry.Resolve(segments[3]), nil
+}
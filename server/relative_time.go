@@ -0,0 +1,87 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var relativeDurationRegexp = regexp.MustCompile(`^(\d+)(w|d|h|m|s)`)
+
+var relativeDurationUnits = map[string]time.Duration{
+	"w": 7 * 24 * time.Hour,
+	"d": 24 * time.Hour,
+	"h": time.Hour,
+	"m": time.Minute,
+	"s": time.Second,
+}
+
+// parseTime parses either an RFC3339 timestamp or an expression relative to now:
+// "now", "startOfDay", "startOfWeek", "startOfMonth", "startOfYear",
+// or a duration into the past such as "-24h", "-7d" or "-1w3d".
+// For durations into the past the duration is returned as well, otherwise it is zero.
+func parseTime(value string, now time.Time) (time.Time, time.Duration, error) {
+	switch value {
+	case "now":
+		return now, 0, nil
+	case "startOfDay":
+		return startOfDay(now), 0, nil
+	case "startOfWeek":
+		// weeks start on Monday
+		day := startOfDay(now)
+		daysSinceMonday := (int(day.Weekday()) + 6) % 7
+		return day.AddDate(0, 0, -daysSinceMonday), 0, nil
+	case "startOfMonth":
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()), 0, nil
+	case "startOfYear":
+		return time.Date(now.Year(), time.January, 1, 0, 0, 0, 0, now.Location()), 0, nil
+	}
+
+	if strings.HasPrefix(value, "-") {
+		d, err := parseRelativeDuration(value[1:])
+		if err != nil {
+			return time.Time{}, 0, err
+		}
+		return now.Add(-d), d, nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, value)
+	return t, 0, err
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// parseRelativeDuration parses durations like "7d" or "1w3d12h".
+// Unlike time.ParseDuration it supports days and weeks, but not fractions.
+func parseRelativeDuration(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, errors.New("empty duration")
+	}
+	var total time.Duration
+	for value != "" {
+		match := relativeDurationRegexp.FindStringSubmatch(value)
+		if match == nil {
+			return 0, fmt.Errorf("invalid duration: %s", value)
+		}
+		n, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		unit := relativeDurationUnits[match[2]]
+		if n > math.MaxInt64/int64(unit) || time.Duration(n)*unit > math.MaxInt64-total {
+			return 0, fmt.Errorf("duration too long: %s", value)
+		}
+		total += time.Duration(n) * unit
+		value = value[len(match[0]):]
+	}
+	if total <= 0 {
+		return 0, errors.New("duration must be positive")
+	}
+	return total, nil
+}
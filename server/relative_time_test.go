@@ -0,0 +1,70 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	helsinki := loadLocation(t, "Europe/Helsinki")
+	// a Sunday, when daylight saving time ends in Helsinki
+	now := time.Date(2022, 10, 30, 15, 4, 5, 0, helsinki)
+	nowUTC := now.UTC()
+
+	tests := []struct {
+		value     string
+		now       time.Time
+		want      time.Time
+		wantSince time.Duration
+	}{
+		{"now", now, now, 0},
+		{"startOfDay", now, time.Date(2022, 10, 30, 0, 0, 0, 0, helsinki), 0},
+		{"startOfDay", nowUTC, time.Date(2022, 10, 30, 0, 0, 0, 0, time.UTC), 0},
+		{"startOfWeek", now, time.Date(2022, 10, 24, 0, 0, 0, 0, helsinki), 0},
+		{"startOfWeek", now.AddDate(0, 0, 1), time.Date(2022, 10, 31, 0, 0, 0, 0, helsinki), 0},
+		{"startOfMonth", now, time.Date(2022, 10, 1, 0, 0, 0, 0, helsinki), 0},
+		{"startOfYear", now, time.Date(2022, 1, 1, 0, 0, 0, 0, helsinki), 0},
+		{"-24h", now, now.Add(-24 * time.Hour), 24 * time.Hour},
+		{"-7d", now, now.Add(-week), week},
+		{"-1w3d", now, now.Add(-10 * day), 10 * day},
+		{"-1d12h30m15s", now, now.Add(-36*time.Hour - 30*time.Minute - 15*time.Second), 36*time.Hour + 30*time.Minute + 15*time.Second},
+		{"2022-10-29T12:00:00+03:00", now, time.Date(2022, 10, 29, 9, 0, 0, 0, time.UTC), 0},
+		{"2022-10-29T12:00:00.5Z", now, time.Date(2022, 10, 29, 12, 0, 0, 5e8, time.UTC), 0},
+	}
+	for _, tt := range tests {
+		got, since, err := parseTime(tt.value, tt.now)
+		if err != nil {
+			t.Errorf("parseTime(%q, %s): %v", tt.value, tt.now, err)
+			continue
+		}
+		if !got.Equal(tt.want) || since != tt.wantSince {
+			t.Errorf("parseTime(%q, %s) = %s, %s, want %s, %s", tt.value, tt.now, got, since, tt.want, tt.wantSince)
+		}
+	}
+}
+
+func TestParseTimeRejectsInvalid(t *testing.T) {
+	now := time.Date(2022, 10, 30, 15, 4, 5, 0, time.UTC)
+	for _, value := range []string{
+		"",
+		"yesterday",
+		"24h",
+		"-",
+		"-0d",
+		"-1.5h",
+		"-1y",
+		"-1w-3d",
+		"-1h ",
+		"-15251w",
+		"-106752d",
+		"-9223372036854775807s",
+		"-99999999999999999999s",
+		"-15250w15250w",
+		"2022-10-30",
+		"2022-10-30T12:00:00",
+	} {
+		if got, _, err := parseTime(value, now); err == nil {
+			t.Errorf("parseTime(%q) = %s, want an error", value, got)
+		}
+	}
+}
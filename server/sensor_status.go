@@ -0,0 +1,119 @@
+package server
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	SensorOnline  = "online"
+	SensorStale   = "stale"
+	SensorOffline = "offline"
+
+	// defaultStatusWindow is how far back reports are looked at when no window is given
+	defaultStatusWindow = 24 * time.Hour
+
+	// maxStatusWindow limits how far back reports are looked at,
+	// as the time of every report within the window is held in memory
+	maxStatusWindow = 7 * 24 * time.Hour
+
+	// fallbackReportInterval is assumed when a sensor has not reported often enough
+	// within the window for its interval to be estimated
+	fallbackReportInterval = 5 * time.Minute
+
+	// a sensor is stale after missing staleAfter expected reports,
+	// and offline after missing offlineAfter expected reports
+	staleAfter   = 3
+	offlineAfter = 30
+)
+
+// SensorStatus tells whether a sensor is reporting as often as it usually does.
+type SensorStatus struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+
+	LastSeen time.Time `json:"lastSeen"`
+
+	// ExpectedInterval is the usual time between reports in seconds,
+	// estimated as the median time between reports within the window
+	ExpectedInterval float64 `json:"expectedInterval"`
+
+	// Uptime is the percentage of expected reports received within the window,
+	// counted from when the sensor was first seen if that is later
+	Uptime float64 `json:"uptime"`
+}
+
+// sensorStatuses classifies sensors by how long ago they last reported compared to how often they usually do.
+func sensorStatuses(sensors []SensorInfo, activity []SensorActivity, window time.Duration, now time.Time) []SensorStatus {
+	reports := make(map[string][]time.Time, len(activity))
+	for _, a := range activity {
+		reports[a.ID] = a.Reports
+	}
+
+	statuses := make([]SensorStatus, 0, len(sensors))
+	for _, sensor := range sensors {
+		times := reports[sensor.ID]
+		interval := expectedInterval(times)
+
+		lastSeen := sensor.LastSeen
+		if n := len(times); n > 0 && times[n-1].After(lastSeen) {
+			lastSeen = times[n-1]
+		}
+
+		status := SensorStatus{
+			ID:               sensor.ID,
+			LastSeen:         lastSeen,
+			ExpectedInterval: interval.Seconds(),
+			Uptime:           uptime(times, interval, now.Add(-window), sensor.FirstSeen, now),
+		}
+		switch age := now.Sub(lastSeen); {
+		case len(times) > 0 && age <= staleAfter*interval:
+			status.Status = SensorOnline
+		case len(times) > 0 && age <= offlineAfter*interval:
+			status.Status = SensorStale
+		default:
+			status.Status = SensorOffline
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// expectedInterval estimates the reporting interval as the median time between reports.
+func expectedInterval(reports []time.Time) time.Duration {
+	if len(reports) < 2 {
+		return fallbackReportInterval
+	}
+	gaps := make([]time.Duration, 0, len(reports)-1)
+	for i := 1; i < len(reports); i++ {
+		gaps = append(gaps, reports[i].Sub(reports[i-1]))
+	}
+	sort.Slice(gaps, func(i, j int) bool {
+		return gaps[i] < gaps[j]
+	})
+	if median := gaps[len(gaps)/2]; median > 0 {
+		return median
+	}
+	return fallbackReportInterval
+}
+
+// uptime returns the percentage of slots of length interval between start, or firstSeen if later,
+// and now which contain at least one report.
+func uptime(reports []time.Time, interval time.Duration, start, firstSeen, now time.Time) float64 {
+	if firstSeen.After(start) {
+		start = firstSeen
+	}
+	slots := int(now.Sub(start)/interval) + 1
+	if len(reports) == 0 || slots <= 0 {
+		return 0
+	}
+
+	reported := make(map[int]bool)
+	for _, t := range reports {
+		if t.Before(start) || t.After(now) {
+			continue
+		}
+		reported[int(t.Sub(start)/interval)] = true
+	}
+	return 100 * float64(len(reported)) / float64(slots)
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+// addReports adds n temperature reports of sensor id, step apart, starting from start.
+func addReports(t *testing.T, store *MemoryStore, id string, n int, start time.Time, step time.Duration) {
+	t.Helper()
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = 20
+	}
+	addSeries(t, store, "temperature", id, values, start, step)
+}
+
+func TestSensorStatuses(t *testing.T) {
+	// the memory store looks at reports within the window from the wall clock,
+	// so the fixed clock of the test is the time it started
+	now := time.Now().UTC().Truncate(time.Second)
+	store := NewMemoryStore()
+	// every 5 minutes since before the window until 4 minutes ago
+	addReports(t, store, "online", 24*12*2, now.Add(-48*time.Hour+time.Minute), 5*time.Minute)
+	// every 10 minutes for 10 hours, ending 2 hours ago
+	addReports(t, store, "stale", 61, now.Add(-12*time.Hour), 10*time.Minute)
+	// every minute for 2 hours, ending an hour ago
+	addReports(t, store, "offline", 121, now.Add(-3*time.Hour), time.Minute)
+	// a single report a minute ago
+	addReports(t, store, "new", 1, now.Add(-time.Minute), 0)
+	// last reported before the window
+	addReports(t, store, "silent", 2, now.Add(-72*time.Hour), 5*time.Minute)
+
+	sensors, err := store.Sensors(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	activity, err := store.Activity(context.Background(), defaultStatusWindow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]SensorStatus{
+		"online": {
+			Status:           SensorOnline,
+			LastSeen:         now.Add(-4 * time.Minute),
+			ExpectedInterval: 300,
+			Uptime:           100 * 288.0 / 289,
+		},
+		"stale": {
+			Status:           SensorStale,
+			LastSeen:         now.Add(-2 * time.Hour),
+			ExpectedInterval: 600,
+			Uptime:           100 * 61.0 / 73,
+		},
+		"offline": {
+			Status:           SensorOffline,
+			LastSeen:         now.Add(-time.Hour),
+			ExpectedInterval: 60,
+			Uptime:           100 * 121.0 / 181,
+		},
+		"new": {
+			Status:           SensorOnline,
+			LastSeen:         now.Add(-time.Minute),
+			ExpectedInterval: fallbackReportInterval.Seconds(),
+			Uptime:           100,
+		},
+		"silent": {
+			Status:           SensorOffline,
+			LastSeen:         now.Add(-72*time.Hour + 5*time.Minute),
+			ExpectedInterval: fallbackReportInterval.Seconds(),
+			Uptime:           0,
+		},
+	}
+
+	statuses := sensorStatuses(sensors, activity, defaultStatusWindow, now)
+	if len(statuses) != len(want) {
+		t.Fatalf("got %d statuses, want %d", len(statuses), len(want))
+	}
+	for _, got := range statuses {
+		w, ok := want[got.ID]
+		if !ok {
+			t.Errorf("unexpected status of %s", got.ID)
+			continue
+		}
+		if got.Status != w.Status || !got.LastSeen.Equal(w.LastSeen) || got.ExpectedInterval != w.ExpectedInterval {
+			t.Errorf("%s is %s, last seen %s, expected every %vs, want %s, %s, %vs",
+				got.ID, got.Status, got.LastSeen, got.ExpectedInterval, w.Status, w.LastSeen, w.ExpectedInterval)
+		}
+		if math.Abs(got.Uptime-w.Uptime) > 1e-9 {
+			t.Errorf("%s has uptime %v, want %v", got.ID, got.Uptime, w.Uptime)
+		}
+	}
+}
+
+func TestExpectedInterval(t *testing.T) {
+	start := time.Date(2022, 10, 30, 0, 0, 0, 0, time.UTC)
+	reports := func(gaps ...time.Duration) []time.Time {
+		times := []time.Time{start}
+		for _, gap := range gaps {
+			times = append(times, times[len(times)-1].Add(gap))
+		}
+		return times
+	}
+	tests := []struct {
+		name    string
+		reports []time.Time
+		want    time.Duration
+	}{
+		{"no reports", nil, fallbackReportInterval},
+		{"one report", reports(), fallbackReportInterval},
+		{"regular", reports(time.Minute, time.Minute, time.Minute), time.Minute},
+		{"outage", reports(time.Minute, time.Minute, 3*time.Hour, time.Minute), time.Minute},
+		{"missed reports", reports(10*time.Minute, 20*time.Minute, 10*time.Minute), 10 * time.Minute},
+		{"duplicates", reports(0, 0, 0, time.Minute), fallbackReportInterval},
+	}
+	for _, tt := range tests {
+		if got := expectedInterval(tt.reports); got != tt.want {
+			t.Errorf("%s: expected interval %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUptime(t *testing.T) {
+	now := time.Date(2022, 10, 30, 12, 0, 0, 0, time.UTC)
+	start := now.Add(-time.Hour)
+	every := func(from time.Time, n int, step time.Duration) []time.Time {
+		var times []time.Time
+		for i := 0; i < n; i++ {
+			times = append(times, from.Add(time.Duration(i)*step))
+		}
+		return times
+	}
+	tests := []struct {
+		name      string
+		reports   []time.Time
+		firstSeen time.Time
+		want      float64
+	}{
+		{"none", nil, start, 0},
+		{"every slot", every(start, 61, time.Minute), start, 100},
+		{"first half", every(start, 30, time.Minute), start, 100 * 30.0 / 61},
+		{"twice per slot", every(start, 122, 30*time.Second), start, 100},
+		{"seen during the window", every(now.Add(-10*time.Minute), 11, time.Minute), now.Add(-10 * time.Minute), 100},
+		{"before the window", every(start.Add(-time.Hour), 30, time.Minute), start.Add(-time.Hour), 0},
+	}
+	for _, tt := range tests {
+		if got := uptime(tt.reports, time.Minute, start, tt.firstSeen, now); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: uptime %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
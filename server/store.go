@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/LassiHeikkila/mokki-cloud/server/registry"
+)
+
+// ErrNoData is returned by a Store when a query matched nothing.
+var ErrNoData = errors.New("no data found")
+
+// ErrUnknownSensor is returned when a sensor has not reported anything.
+var ErrUnknownSensor = errors.New("unknown sensor")
+
+// ErrCalibrationChanged is returned when statistics are asked of a range within which
+// the calibration of the field changes, as they cannot be calibrated as a whole.
+var ErrCalibrationChanged = errors.New("calibration changes within range")
+
+// Store is the source of measurement data served by the API.
+// Fields derived from other fields, see DerivedFields, are accepted wherever a field is.
+type Store interface {
+	// Latest returns the most recent measurement of field from sensor id.
+	Latest(ctx context.Context, field, id string) (Measurement, error)
+
+	// Range returns measurements matching query, and the number of measurements left out
+	// as implausible, see FieldType, or as spikes if Despike of query is set.
+	Range(ctx context.Context, query RangeQuery) ([]Measurement, int, error)
+
+	// StreamRange passes measurements matching query to fn one at a time, as they are read.
+	// The number of measurements left out like in Range is passed to rejected once,
+	// before the first measurement is passed to fn.
+	// Streaming stops at the first error returned by fn.
+	// ErrNoData is returned if nothing matched query.
+	StreamRange(ctx context.Context, query RangeQuery, rejected func(int), fn func(Measurement) error) error
+
+	// Envelope returns the minimum, mean and maximum of each aggregation window of query,
+	// and the number of measurements left out like in Range.
+	// Aggregate of query is ignored.
+	Envelope(ctx context.Context, query RangeQuery) ([]EnvelopePoint, int, error)
+
+	// Stats returns summary statistics of the measurements between Start and Stop of query.
+	// Interval and Aggregate of query are ignored.
+	// ErrCalibrationChanged may be returned if the calibration of the field changes
+	// between Start and Stop.
+	Stats(ctx context.Context, query RangeQuery) (Stats, error)
+
+	// GroupRange returns the measurements of query from each of sensorIDs,
+	// aggregated into the windows of query separately for each sensor,
+	// and the number of measurements of all of them left out like in Range.
+	// SensorID of query is ignored and query must have an Interval or Months.
+	GroupRange(ctx context.Context, query RangeQuery, sensorIDs []string) ([]Measurement, int, error)
+
+	// Sensors returns all known sensors.
+	Sensors(ctx context.Context) ([]SensorInfo, error)
+
+	// Fields returns the fields reported by sensor id, and the fields derived from them.
+	Fields(ctx context.Context, id string) ([]string, error)
+
+	// Snapshot returns the latest measurement of each snapshot field for every sensor.
+	Snapshot(ctx context.Context) ([]Snapshot, error)
+
+	// Activity returns when each sensor has reported during the past window, relative to the store's clock.
+	Activity(ctx context.Context, window time.Duration) ([]SensorActivity, error)
+}
+
+// SnapshotFields are the fields included in a Snapshot.
+var SnapshotFields = []string{
+	"temperature",
+	"humidity",
+	"pressure",
+	"batteryvoltage",
+	"co2",
+	"pm2p5",
+}
+
+// Snapshot holds the latest measurements of one sensor, keyed by field.
+type Snapshot struct {
+	SensorID     string                 `json:"sensorID"`
+	Measurements map[string]Measurement `json:"measurements"`
+}
+
+// RangeQuery selects measurements of Field from sensor SensorID between Start and Stop,
+// aggregated into windows of length Interval using Aggregate.
+// Zero Interval selects raw measurements.
+// Non-zero Since selects the measurements of the past Since relative to the store's clock instead,
+// in which case Start and Stop are only approximations of the range.
+type RangeQuery struct {
+	Field     string
+	SensorID  string
+	Start     time.Time
+	Stop      time.Time
+	Since     time.Duration
+	Interval  time.Duration
+	Aggregate Aggregate
+
+	// Months is the length of aggregation windows in calendar months. If non-zero, Interval is ignored.
+	Months int
+
+	// Location is the time zone whose midnight windows of whole days are aligned to.
+	// Nil means UTC.
+	Location *time.Location
+
+	// Raw keeps measurements outside the bounds of the field, see FieldType.
+	Raw bool
+
+	// Despike rejects spikes from raw measurements before aggregation.
+	Despike bool
+}
+
+// EnvelopePoint holds the minimum, mean and maximum of one aggregation window.
+type EnvelopePoint struct {
+	SensorID string    `json:"sensorID"`
+	Field    string    `json:"field"`
+	Min      float64   `json:"min"`
+	Mean     float64   `json:"mean"`
+	Max      float64   `json:"max"`
+	Unit     string    `json:"unit,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// Stats summarizes the measurements of one field of one sensor over a time range.
+type Stats struct {
+	SensorID string      `json:"sensorID"`
+	Field    string      `json:"field"`
+	From     time.Time   `json:"from"`
+	To       time.Time   `json:"to"`
+	Count    int         `json:"count"`
+	Min      Measurement `json:"min"`
+	Max      Measurement `json:"max"`
+	Mean     float64     `json:"mean"`
+	Median   float64     `json:"median"`
+	StdDev   float64     `json:"stddev"`
+	First    Measurement `json:"first"`
+	Last     Measurement `json:"last"`
+	Unit     string      `json:"unit,omitempty"`
+}
+
+// SensorInfo describes a sensor and the data it has reported.
+// Metadata is set by the API for sensors found in the registry.
+type SensorInfo struct {
+	ID        string           `json:"id"`
+	Fields    []string         `json:"fields"`
+	FirstSeen time.Time        `json:"firstSeen"`
+	LastSeen  time.Time        `json:"lastSeen"`
+	Metadata  *registry.Sensor `json:"metadata,omitempty"`
+}
+
+// SensorActivity holds the times a sensor has reported, in time order.
+// Measurements of several fields reported at once count as one report.
+type SensorActivity struct {
+	ID      string
+	Reports []time.Time
+}
@@ -0,0 +1,235 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// unitConversion converts values of a field from the unit it is stored in to unit, as gain * value + offset.
+type unitConversion struct {
+	unit   string
+	gain   float64
+	offset float64
+}
+
+// apply converts v holding the result of aggregate agg, see calibrateValue.
+func (c unitConversion) apply(v float64, agg string) float64 {
+	switch agg {
+	case "count":
+		return v
+	case "stddev":
+		return math.Abs(c.gain) * v
+	case "sum":
+		return c.gain * v
+	}
+	return c.gain*v + c.offset
+}
+
+var temperatureUnits = map[string]unitConversion{
+	"c": {unit: "°C", gain: 1},
+	"f": {unit: "°F", gain: 1.8, offset: 32},
+	"k": {unit: "K", gain: 1, offset: 273.15},
+}
+
+// fieldUnits are the units fields can be converted to, keyed by lower case unit name.
+// Other fields are always returned in the unit they are stored in.
+var fieldUnits = map[string]map[string]unitConversion{
+	"temperature": temperatureUnits,
+	"dewpoint":    temperatureUnits,
+	"pressure": {
+		"pa":   {unit: "Pa", gain: 1},
+		"hpa":  {unit: "hPa", gain: 0.01},
+		"kpa":  {unit: "kPa", gain: 0.001},
+		"mbar": {unit: "mbar", gain: 0.01},
+		"inhg": {unit: "inHg", gain: 1 / 3386.389},
+		"mmhg": {unit: "mmHg", gain: 1 / 133.322387415},
+	},
+	"absolutehumidity": {
+		"g/m3":   {unit: "g/m³", gain: 1},
+		"gr/ft3": {unit: "gr/ft³", gain: 0.43699572},
+	},
+}
+
+// imperialUnits are the units selected by units=imperial.
+var imperialUnits = map[string]string{
+	"temperature":      "f",
+	"dewpoint":         "f",
+	"pressure":         "inhg",
+	"absolutehumidity": "gr/ft3",
+}
+
+// Units maps fields to the units their values are returned in.
+// Fields not in Units are returned in the units they are stored in, as with units=metric.
+type Units map[string]unitConversion
+
+// ParseUnits parses a comma separated list of "metric", "imperial" and field=unit pairs,
+// e.g. "imperial,pressure=hPa". Later items override earlier ones. Empty value selects metric units.
+func ParseUnits(value string) (Units, error) {
+	units := Units{}
+	if value == "" {
+		return units, nil
+	}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		switch strings.ToLower(item) {
+		case "metric":
+			units = Units{}
+			continue
+		case "imperial":
+			for field, unit := range imperialUnits {
+				units[field] = fieldUnits[field][unit]
+			}
+			continue
+		}
+		field, unit, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid units: %s", item)
+		}
+		conversion, ok := fieldUnits[field][strings.ToLower(unit)]
+		if !ok {
+			return nil, fmt.Errorf("unsupported unit for %s: %s", field, unit)
+		}
+		units[field] = conversion
+	}
+	return units, nil
+}
+
+// unit returns the unit values of field are returned in.
+func (u Units) unit(field string) string {
+	if conversion, ok := u[field]; ok {
+		return conversion.unit
+	}
+	return fieldTypes[field].Unit
+}
+
+// convertValue converts v of field holding the result of aggregate agg.
+func (u Units) convertValue(field string, v float64, agg string) float64 {
+	if conversion, ok := u[field]; ok {
+		return conversion.apply(v, agg)
+	}
+	return v
+}
+
+// convert returns m in the unit selected for its field.
+// Counts are not converted and are labelled with no unit.
+func (u Units) convert(m Measurement, agg string) Measurement {
+	if m == nil {
+		return nil
+	}
+	if _, empty := m.(*emptyMeasurement); empty {
+		return m
+	}
+	converted := *asMeasurement(m)
+	if agg == "count" {
+		converted.unit = ""
+		return &converted
+	}
+	if conversion, ok := u[converted.field]; ok {
+		if v, ok := floatValue(m); ok {
+			converted.value = conversion.apply(v, agg)
+			converted.unit = conversion.unit
+		}
+	}
+	return &converted
+}
+
+func (u Units) convertAll(measurements []Measurement, agg string) []Measurement {
+	converted := make([]Measurement, 0, len(measurements))
+	for _, m := range measurements {
+		converted = append(converted, u.convert(m, agg))
+	}
+	return converted
+}
+
+// unitStore is a Store returning values in the units selected for a request, labelled with their units.
+type unitStore struct {
+	Store
+	units Units
+}
+
+func withUnits(store Store, units Units) Store {
+	return unitStore{Store: store, units: units}
+}
+
+func rangeAggregate(query RangeQuery) string {
+	if query.Interval > 0 || query.Months > 0 {
+		return query.Aggregate.Function
+	}
+	return ""
+}
+
+func (s unitStore) Latest(ctx context.Context, field, id string) (Measurement, error) {
+	m, err := s.Store.Latest(ctx, field, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.units.convert(m, ""), nil
+}
+
+func (s unitStore) Range(ctx context.Context, query RangeQuery) ([]Measurement, int, error) {
+	measurements, rejected, err := s.Store.Range(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+	return s.units.convertAll(measurements, rangeAggregate(query)), rejected, nil
+}
+
+func (s unitStore) StreamRange(ctx context.Context, query RangeQuery, rejected func(int), fn func(Measurement) error) error {
+	agg := rangeAggregate(query)
+	return s.Store.StreamRange(ctx, query, rejected, func(m Measurement) error {
+		return fn(s.units.convert(m, agg))
+	})
+}
+
+func (s unitStore) GroupRange(ctx context.Context, query RangeQuery, sensorIDs []string) ([]Measurement, int, error) {
+	measurements, rejected, err := s.Store.GroupRange(ctx, query, sensorIDs)
+	if err != nil {
+		return nil, 0, err
+	}
+	return s.units.convertAll(measurements, query.Aggregate.Function), rejected, nil
+}
+
+func (s unitStore) Envelope(ctx context.Context, query RangeQuery) ([]EnvelopePoint, int, error) {
+	points, rejected, err := s.Store.Envelope(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range points {
+		points[i].Min = s.units.convertValue(query.Field, points[i].Min, "min")
+		points[i].Mean = s.units.convertValue(query.Field, points[i].Mean, "mean")
+		points[i].Max = s.units.convertValue(query.Field, points[i].Max, "max")
+		points[i].Unit = s.units.unit(query.Field)
+	}
+	return points, rejected, nil
+}
+
+func (s unitStore) Stats(ctx context.Context, query RangeQuery) (Stats, error) {
+	stats, err := s.Store.Stats(ctx, query)
+	if err != nil {
+		return Stats{}, err
+	}
+	stats.Min = s.units.convert(stats.Min, "min")
+	stats.Max = s.units.convert(stats.Max, "max")
+	stats.First = s.units.convert(stats.First, "first")
+	stats.Last = s.units.convert(stats.Last, "last")
+	stats.Mean = s.units.convertValue(query.Field, stats.Mean, "mean")
+	stats.Median = s.units.convertValue(query.Field, stats.Median, "median")
+	stats.StdDev = s.units.convertValue(query.Field, stats.StdDev, "stddev")
+	stats.Unit = s.units.unit(query.Field)
+	return stats, nil
+}
+
+func (s unitStore) Snapshot(ctx context.Context) ([]Snapshot, error) {
+	snapshots, err := s.Store.Snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, snapshot := range snapshots {
+		for field, m := range snapshot.Measurements {
+			snapshot.Measurements[field] = s.units.convert(m, "")
+		}
+	}
+	return snapshots, nil
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseUnits(t *testing.T) {
+	tests := []struct {
+		value string
+		want  map[string]string
+	}{
+		{"", map[string]string{}},
+		{"metric", map[string]string{}},
+		{"imperial", map[string]string{"temperature": "°F", "dewpoint": "°F", "pressure": "inHg", "absolutehumidity": "gr/ft³"}},
+		{"Imperial", map[string]string{"temperature": "°F", "dewpoint": "°F", "pressure": "inHg", "absolutehumidity": "gr/ft³"}},
+		{"imperial,pressure=hPa", map[string]string{"temperature": "°F", "dewpoint": "°F", "pressure": "hPa", "absolutehumidity": "gr/ft³"}},
+		{"imperial, metric", map[string]string{}},
+		{"temperature=K,pressure=mmhg", map[string]string{"temperature": "K", "pressure": "mmHg"}},
+		{"dewpoint=f, absolutehumidity=g/m3", map[string]string{"dewpoint": "°F", "absolutehumidity": "g/m³"}},
+	}
+	for _, tt := range tests {
+		units, err := ParseUnits(tt.value)
+		if err != nil {
+			t.Errorf("ParseUnits(%q): %v", tt.value, err)
+			continue
+		}
+		if len(units) != len(tt.want) {
+			t.Errorf("ParseUnits(%q) selects units of %d fields, want %d", tt.value, len(units), len(tt.want))
+		}
+		for field, unit := range tt.want {
+			if got := units.unit(field); got != unit {
+				t.Errorf("ParseUnits(%q) returns %s in %q, want %q", tt.value, field, got, unit)
+			}
+		}
+	}
+
+	for _, value := range []string{
+		"si",
+		"temperature",
+		"temperature=R",
+		"Temperature=F",
+		"humidity=g/m3",
+		"humidex=F",
+		"pressure=",
+		"imperial,",
+		"=hPa",
+	} {
+		if _, err := ParseUnits(value); err == nil {
+			t.Errorf("ParseUnits(%q) was accepted", value)
+		}
+	}
+}
+
+func TestUnitConversions(t *testing.T) {
+	tests := []struct {
+		field, unit string
+		v, want     float64
+	}{
+		{"temperature", "c", 21.5, 21.5},
+		{"temperature", "f", 100, 212},
+		{"temperature", "f", -40, -40},
+		{"temperature", "k", 0, 273.15},
+		{"dewpoint", "f", 0, 32},
+		{"dewpoint", "k", -273.15, 0},
+		{"pressure", "pa", 101325, 101325},
+		{"pressure", "hpa", 101325, 1013.25},
+		{"pressure", "kpa", 101325, 101.325},
+		{"pressure", "mbar", 101325, 1013.25},
+		{"pressure", "inhg", 101325, 29.921},
+		{"pressure", "mmhg", 101325, 760},
+		{"absolutehumidity", "g/m3", 17.3, 17.3},
+		{"absolutehumidity", "gr/ft3", 10, 4.370},
+	}
+	for _, tt := range tests {
+		conversion := fieldUnits[tt.field][tt.unit]
+		if got := conversion.apply(tt.v, ""); math.Abs(got-tt.want) > 1e-3 {
+			t.Errorf("%v of %s is %v %s, want %v", tt.v, tt.field, got, conversion.unit, tt.want)
+		}
+	}
+}
+
+func TestUnitConversionsRoundTripBounds(t *testing.T) {
+	for field, units := range fieldUnits {
+		bounds, ok := fieldBounds(field)
+		if !ok {
+			t.Errorf("%s has no bounds", field)
+			continue
+		}
+		for name, conversion := range units {
+			min, max := conversion.apply(bounds.Min, "min"), conversion.apply(bounds.Max, "max")
+			if min >= max {
+				t.Errorf("bounds of %s are [%v, %v] in %s, want min below max", field, min, max, name)
+			}
+			for _, b := range []struct{ stored, converted float64 }{{bounds.Min, min}, {bounds.Max, max}} {
+				back := (b.converted - conversion.offset) / conversion.gain
+				if math.Abs(back-b.stored) > 1e-9*math.Max(1, math.Abs(b.stored)) {
+					t.Errorf("bound %v of %s converts to %v %s and back to %v", b.stored, field, b.converted, name, back)
+				}
+			}
+		}
+	}
+}
+
+func TestUnitConversionOfAggregates(t *testing.T) {
+	fahrenheit := fieldUnits["temperature"]["f"]
+	tests := []struct {
+		agg  string
+		v    float64
+		want float64
+	}{
+		{"", 10, 50},
+		{"mean", 10, 50},
+		{"min", -10, 14},
+		{"quantile", 10, 50},
+		{"stddev", 2, 3.6},
+		{"sum", 10, 18},
+		{"count", 10, 10},
+	}
+	for _, tt := range tests {
+		if got := fahrenheit.apply(tt.v, tt.agg); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s %v °C is %v °F, want %v", tt.agg, tt.v, got, tt.want)
+		}
+	}
+
+	units, err := ParseUnits("imperial")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewMeasurement("temperature", "sensor", 10.0, time.Date(2022, 10, 30, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if converted := asMeasurement(units.convert(m, "")); converted.value != 50.0 || converted.unit != "°F" {
+		t.Errorf("converted %v to %v %q, want 50 °F", m, converted.value, converted.unit)
+	}
+	if counted := asMeasurement(units.convert(m, "count")); counted.value != 10.0 || counted.unit != "" {
+		t.Errorf("converted count %v to %v %q, want 10 without unit", m, counted.value, counted.unit)
+	}
+	if original := asMeasurement(m); original.value != 10.0 || original.unit != "°C" {
+		t.Errorf("convert modified the measurement to %v %q", original.value, original.unit)
+	}
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"time"
+)
+
+// virtualStore is a Store adding virtual fields computed in the server on top of another Store,
+// such as the mould index. Other fields are passed through as is.
+type virtualStore struct {
+	Store
+}
+
+// withVirtualFields wraps store so that virtual fields can be queried like any other field.
+func withVirtualFields(store Store) Store {
+	if _, ok := store.(virtualStore); ok {
+		return store
+	}
+	return virtualStore{Store: store}
+}
+
+func isVirtualField(field string) bool {
+	return field == mouldIndexField
+}
+
+// series computes the virtual field of query over its range,
+// and returns the number of input values left out like in Store.Range.
+func (s virtualStore) series(ctx context.Context, query RangeQuery) ([]Measurement, int, error) {
+	sensitivity, _ := ParseMouldSensitivity("")
+	return mouldIndexSeries(ctx, s.Store, query, sensitivity)
+}
+
+func (s virtualStore) Latest(ctx context.Context, field, id string) (Measurement, error) {
+	if !isVirtualField(field) {
+		return s.Store.Latest(ctx, field, id)
+	}
+	now := time.Now()
+	series, _, err := s.series(ctx, RangeQuery{
+		Field:    field,
+		SensorID: id,
+		Start:    now.Add(-mouldLookback),
+		Stop:     now,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return series[len(series)-1], nil
+}
+
+func (s virtualStore) Range(ctx context.Context, query RangeQuery) ([]Measurement, int, error) {
+	if !isVirtualField(query.Field) {
+		return s.Store.Range(ctx, query)
+	}
+	series, rejected, err := s.series(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+	if query.Interval <= 0 && query.Months <= 0 {
+		return series, rejected, nil
+	}
+	aggregated, err := aggregateWindows(query.Field, query.SensorID, series, query.window(), query.Aggregate)
+	if err != nil {
+		return nil, 0, err
+	}
+	return aggregated, rejected, nil
+}
+
+func (s virtualStore) StreamRange(ctx context.Context, query RangeQuery, rejected func(int), fn func(Measurement) error) error {
+	if !isVirtualField(query.Field) {
+		return s.Store.StreamRange(ctx, query, rejected, fn)
+	}
+	measurements, n, err := s.Range(ctx, query)
+	if err != nil {
+		return err
+	}
+	rejected(n)
+	for _, m := range measurements {
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s virtualStore) Envelope(ctx context.Context, query RangeQuery) ([]EnvelopePoint, int, error) {
+	if !isVirtualField(query.Field) {
+		return s.Store.Envelope(ctx, query)
+	}
+	series, rejected, err := s.series(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+	points, err := envelopeFromSeries(query, series)
+	if err != nil {
+		return nil, 0, err
+	}
+	return points, rejected, nil
+}
+
+func (s virtualStore) Stats(ctx context.Context, query RangeQuery) (Stats, error) {
+	if !isVirtualField(query.Field) {
+		return s.Store.Stats(ctx, query)
+	}
+	series, _, err := s.series(ctx, query)
+	if err != nil {
+		return Stats{}, err
+	}
+	return statsFromSeries(query, series)
+}
+
+func (s virtualStore) GroupRange(ctx context.Context, query RangeQuery, sensorIDs []string) ([]Measurement, int, error) {
+	if !isVirtualField(query.Field) {
+		return s.Store.GroupRange(ctx, query, sensorIDs)
+	}
+	return groupRangeBySensor(ctx, s, query, sensorIDs)
+}